@@ -2,6 +2,9 @@ package main
 
 import (
 	"errors"
+	"follow"
+	"geo"
+	"gps"
 	"machine"
 	"strconv"
 	"strings"
@@ -10,9 +13,28 @@ import (
 
 const (
 	I_GPSBAUD = iota
+	I_GPSTYPE
+	I_GPSRATE
 	I_MSPBAUD
+	I_MSPVERS
+	I_MSPRETRY
+	I_MSPTIMEOUT
 	I_VOFFSET
 	I_RESETHOME
+	I_WPVERIFY
+	I_FOLLOWMODE
+	I_FOLLOWDIST
+	I_FOLLOWBRG
+	I_KFENABLE
+	I_KFMAXHDOP
+	I_LEADHORIZON
+	I_LEADMAX
+	I_FENCERANGE
+	I_FENCESTEP
+	I_ALTMODE
+	I_ALTOFFSET
+	I_ALTMIN
+	I_ALTMAX
 	I_NSATS
 	I_HELP
 	I_NONE
@@ -35,9 +57,28 @@ type CLIMsg struct {
 
 var Climsgs = []CLIMsg{
 	{I_GPSBAUD, "gps_baud", cmdfunc(vbaud), "1200", "115200"},
+	{I_GPSTYPE, "gps_type", cmdfunc(vgpstype), "0/none", "3/quectel"},
+	{I_GPSRATE, "gps_rate", cmdfunc(vgpsrate), "0/default", "10"},
 	{I_MSPBAUD, "msp_baud", cmdfunc(vbaud), "1200", "115200"},
+	{I_MSPVERS, "msp_version", cmdfunc(vmspvers), "0/auto", "2"},
+	{I_MSPRETRY, "msp_retries", cmdfunc(vretries), "0", "9"},
+	{I_MSPTIMEOUT, "msp_timeout", cmdfunc(vmsptimeout), "50", "5000"},
 	{I_VOFFSET, "vbat_offset", cmdfunc(voffset), "0.0", "1.8"},
 	{I_RESETHOME, "reset_home", cmdfunc(vbool), "0/false", "1/true"},
+	{I_WPVERIFY, "wp_verify", cmdfunc(vbool), "0/false", "1/true"},
+	{I_FOLLOWMODE, "follow_mode", cmdfunc(vfollowmode), "0", "3"},
+	{I_FOLLOWDIST, "follow_dist", cmdfunc(vfollowdist), "0", "500"},
+	{I_FOLLOWBRG, "follow_bearing", cmdfunc(vbearing), "0", "359"},
+	{I_KFENABLE, "kf_enable", cmdfunc(vbool), "0/false", "1/true"},
+	{I_KFMAXHDOP, "kf_max_hdop", cmdfunc(vmaxhdop), "1.0", "20.0"},
+	{I_LEADHORIZON, "lead_horizon", cmdfunc(vhorizon), "0.0", "10.0"},
+	{I_LEADMAX, "lead_max", cmdfunc(vleadmax), "0", "100"},
+	{I_FENCERANGE, "fence_range", cmdfunc(vfencerange), "0", "10000"},
+	{I_FENCESTEP, "fence_step", cmdfunc(vfencestep), "0", "500"},
+	{I_ALTMODE, "alt_mode", cmdfunc(valtmode), "0", "2"},
+	{I_ALTOFFSET, "alt_offset", cmdfunc(valt), "-500", "500"},
+	{I_ALTMIN, "alt_min", cmdfunc(valt), "-500", "500"},
+	{I_ALTMAX, "alt_max", cmdfunc(valt), "-500", "500"},
 	{I_NSATS, "minsats", cmdfunc(vsats), "3", "99"},
 	{I_HELP, "help", nil, "", ""},
 	{I_HELP, "list", nil, "", ""},
@@ -58,6 +99,157 @@ func vbaud(s string) (int32, error) {
 	return iv, err
 }
 
+func vgpstype(s string) (int32, error) {
+	iv, err := gps.ParseReceiver(s)
+	return int32(iv), err
+}
+
+func vgpsrate(s string) (int32, error) {
+	if len(s) > 0 && s[0] == 'd' {
+		return 0, nil
+	}
+	iv, err := parseInt(s)
+	if err == nil {
+		if iv < 0 || iv > 10 {
+			return 0, errors.New("Invalid GPS rate")
+		}
+	}
+	return iv, err
+}
+
+func vmspvers(s string) (int32, error) {
+	if len(s) > 0 && s[0] == 'a' {
+		return 0, nil
+	}
+	iv, err := parseInt(s)
+	if err == nil {
+		if iv < 0 || iv > 2 {
+			return 0, errors.New("Invalid MSP version")
+		}
+	}
+	return iv, err
+}
+
+func vretries(s string) (int32, error) {
+	iv, err := parseInt(s)
+	if err == nil {
+		if iv < 0 || iv > 9 {
+			return 0, errors.New("Invalid retries")
+		}
+	}
+	return iv, err
+}
+
+func vmsptimeout(s string) (int32, error) {
+	iv, err := parseInt(s)
+	if err == nil {
+		if iv < 50 || iv > 5000 {
+			return 0, errors.New("Invalid timeout (ms)")
+		}
+	}
+	return iv, err
+}
+
+func vfollowmode(s string) (int32, error) {
+	iv, err := parseInt(s)
+	if err == nil {
+		if iv < geo.FOLLOW_DIRECT || iv > geo.FOLLOW_CHASE {
+			return 0, errors.New("Invalid follow mode")
+		}
+	}
+	return iv, err
+}
+
+func vfollowdist(s string) (int32, error) {
+	iv, err := parseInt(s)
+	if err == nil {
+		if iv < 0 || iv > 500 {
+			return 0, errors.New("Invalid follow distance (m)")
+		}
+	}
+	return iv, err
+}
+
+func vbearing(s string) (int32, error) {
+	iv, err := parseInt(s)
+	if err == nil {
+		if iv < 0 || iv > 359 {
+			return 0, errors.New("Invalid bearing")
+		}
+	}
+	return iv, err
+}
+
+func vmaxhdop(s string) (int32, error) {
+	iv, err := parseScaledFloat(s)
+	if err == nil {
+		if iv < 1000 || iv > 20000 {
+			return iv, errors.New("Invalid HDOP [1.0 - 20.0]")
+		}
+	}
+	return iv, err
+}
+
+func vhorizon(s string) (int32, error) {
+	iv, err := parseScaledFloat(s)
+	if err == nil {
+		if iv < 0 || iv > 10000 {
+			return iv, errors.New("Invalid horizon [0 - 10.0]")
+		}
+	}
+	return iv, err
+}
+
+func vleadmax(s string) (int32, error) {
+	iv, err := parseInt(s)
+	if err == nil {
+		if iv < 0 || iv > 100 {
+			return 0, errors.New("Invalid lead (m)")
+		}
+	}
+	return iv, err
+}
+
+func vfencerange(s string) (int32, error) {
+	iv, err := parseInt(s)
+	if err == nil {
+		if iv < 0 || iv > 10000 {
+			return 0, errors.New("Invalid range (m)")
+		}
+	}
+	return iv, err
+}
+
+func vfencestep(s string) (int32, error) {
+	iv, err := parseInt(s)
+	if err == nil {
+		if iv < 0 || iv > 500 {
+			return 0, errors.New("Invalid step (m)")
+		}
+	}
+	return iv, err
+}
+
+func valtmode(s string) (int32, error) {
+	iv, err := parseInt(s)
+	if err == nil {
+		if iv < follow.ALT_HOLD || iv > follow.ALT_RELATIVE {
+			return 0, errors.New("Invalid altitude mode")
+		}
+	}
+	return iv, err
+}
+
+func valt(s string) (int32, error) {
+	iv, err := parseInt(s)
+	if err == nil {
+		if iv < -500 || iv > 500 {
+			return 0, errors.New("Invalid altitude (m)")
+		}
+	}
+	return iv, err
+}
+
 func vsats(s string) (int32, error) {
 	iv, err := parseInt(s)
 	if err == nil {
@@ -147,14 +339,52 @@ func process_input(mchan chan EditMsg, s string) {
 				switch cl.Id {
 				case I_GPSBAUD:
 					print(GpsBaud)
+				case I_GPSTYPE:
+					print(gps.ReceiverName(int(GpsType)))
+				case I_GPSRATE:
+					print(GpsRate)
 				case I_MSPBAUD:
 					print(MspBaud)
+				case I_MSPVERS:
+					print(MspVersion)
+				case I_MSPRETRY:
+					print(MspRetries)
+				case I_MSPTIMEOUT:
+					print(MspTimeout)
 				case I_NSATS:
 					print(MinSat)
 				case I_VOFFSET:
 					print(strconv.FormatFloat(float64(VBatOffset), 'f', -1, 32))
 				case I_RESETHOME:
 					print(ResetHome)
+				case I_WPVERIFY:
+					print(WpVerify)
+				case I_FOLLOWMODE:
+					print(FollowMode)
+				case I_FOLLOWDIST:
+					print(FollowDist)
+				case I_FOLLOWBRG:
+					print(FollowBrg)
+				case I_KFENABLE:
+					print(KfEnable)
+				case I_KFMAXHDOP:
+					print(strconv.FormatFloat(float64(KfMaxHdop)/1000, 'f', -1, 32))
+				case I_LEADHORIZON:
+					print(strconv.FormatFloat(float64(LeadHorizon)/1000, 'f', -1, 32))
+				case I_LEADMAX:
+					print(LeadMax)
+				case I_FENCERANGE:
+					print(FenceRange)
+				case I_FENCESTEP:
+					print(FenceStep)
+				case I_ALTMODE:
+					print(AltMode)
+				case I_ALTOFFSET:
+					print(AltOffset)
+				case I_ALTMIN:
+					print(AltMin)
+				case I_ALTMAX:
+					print(AltMax)
 				}
 				print(" [")
 				print(cl.vmin)
@@ -0,0 +1,33 @@
+module followme
+
+go 1.19
+
+require (
+	follow v1.0.0
+	gps v1.0.0
+	msp v1.0.0
+	transport v1.0.0
+)
+
+require (
+	fence v1.0.0 // indirect
+	filter v1.0.0 // indirect
+	geo v1.0.0 // indirect
+	github.com/creack/goselect v0.1.2 // indirect
+	go.bug.st/serial v1.4.0 // indirect
+	golang.org/x/sys v0.0.0-20210823070655-63515b42dcdf // indirect
+)
+
+replace fence v1.0.0 => ../../pkg/fence
+
+replace filter v1.0.0 => ../../pkg/filter
+
+replace follow v1.0.0 => ../../pkg/follow
+
+replace geo v1.0.0 => ../../pkg/geo
+
+replace gps v1.0.0 => ../../pkg/gps
+
+replace msp v1.0.0 => ../../pkg/msp
+
+replace transport v1.0.0 => ../../pkg/transport
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"gps"
+	"io"
+	"msp"
+	"time"
+)
+
+// gpsPort is the gps.Port of a transport; input is read by a separate
+// goroutine so that ReadFor can time out
+type gpsPort struct {
+	rw    io.ReadWriter
+	p     *gps.Parser
+	data  chan []byte
+	fchan chan gps.Fix
+}
+
+func (g *gpsPort) SetBaud(baud uint32) {
+	if b, ok := g.rw.(msp.BaudSetter); ok {
+		b.SetBaud(baud)
+	}
+}
+
+func (g *gpsPort) Write(b []byte) (int, error) {
+	return g.rw.Write(b)
+}
+
+func (g *gpsPort) ReadFor(d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	for {
+		select {
+		case b, ok := <-g.data:
+			if !ok {
+				// wait out the period, the read error is reported
+				g.data = nil
+				continue
+			}
+			g.parse(b)
+		case <-timer.C:
+			return
+		}
+	}
+}
+
+func (g *gpsPort) parse(b []byte) {
+	for _, c := range b {
+		if g.p.Parse(c) {
+			g.fchan <- g.p.Fix
+		}
+	}
+}
+
+// read passes input to g.data; a read error is sent to echan
+func (g *gpsPort) read(echan chan error) {
+	defer close(g.data)
+	buf := make([]byte, 256)
+	for {
+		n, err := g.rw.Read(buf)
+		if err != nil {
+			echan <- err
+			return
+		}
+		if n == 0 {
+			echan <- io.EOF
+			return
+		}
+		g.data <- append([]byte(nil), buf[:n]...)
+	}
+}
+
+// gpsReader probes the baud rate (if auto) and configures the receiver
+// (unless rx.Type is gps.RX_NONE), reporting to schan, then passes fixes
+// to fchan; a read error is sent to echan
+func gpsReader(rw io.ReadWriter, auto bool, rx gps.Receiver, fchan chan gps.Fix, schan chan gps.Status, echan chan error) {
+	g := &gpsPort{rw: rw, p: gps.NewParser(), data: make(chan []byte, 16), fchan: fchan}
+	go g.read(echan)
+	report := func(s gps.Status) {
+		schan <- s
+	}
+	if auto {
+		gps.Probe(g, g.p, rx.Baud, report)
+	}
+	if rx.Type != gps.RX_NONE {
+		gps.Configure(g, g.p, rx, report)
+	}
+	for b := range g.data {
+		g.parse(b)
+	}
+}
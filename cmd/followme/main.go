@@ -0,0 +1,199 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"follow"
+	"gps"
+	"io"
+	"log"
+	"msp"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+	"transport"
+)
+
+const (
+	VERSION = "v1.2.0"
+)
+
+type clock struct{}
+
+func (clock) Now() time.Time {
+	return time.Now()
+}
+
+// showName reports the device node of a PTY, for the other end to open
+func showName(what string, t msp.Transport) {
+	if n, ok := t.(transport.Named); ok {
+		fmt.Fprintf(os.Stderr, "%s on %s\n", what, n.Name())
+	}
+}
+
+// parseGNSS converts a list of constellations to a gps.GNSS_* mask
+func parseGNSS(s string) (int, error) {
+	names := map[string]int{"gps": gps.GNSS_GPS, "glonass": gps.GNSS_GLONASS,
+		"galileo": gps.GNSS_GALILEO, "beidou": gps.GNSS_BEIDOU}
+	mask := 0
+	for _, n := range strings.Split(s, ",") {
+		if n = strings.ToLower(strings.TrimSpace(n)); n == "" {
+			continue
+		}
+		v, ok := names[n]
+		if !ok {
+			return 0, fmt.Errorf("unknown constellation %s", n)
+		}
+		mask |= v
+	}
+	return mask, nil
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of followme [options]\n")
+		fmt.Fprintf(os.Stderr, " devices are serial device nodes, tcp://host:port, udp://host:port or pty\n")
+		flag.PrintDefaults()
+	}
+
+	// defaults as the firmware's prefs.go
+	cfg := follow.Config{
+		MinFollowDist:     2.0,
+		DontFollowType:    msp.PLATFORM_AIRPLANE,
+		TimeFormat:        "15:04:05",
+		MspRetries:        3,
+		WpVerifyRetries:   2,
+		WpVerifyTolerance: 1.0,
+		KfAccel:           1.0,
+	}
+
+	gpsdev := flag.String("gps", "", "GPS device")
+	gpsbaud := flag.Int("gps-baud", 9600, "GPS baud rate")
+	autobaud := flag.Bool("gps-autobaud", false, "Probe for the GPS baud rate (serial devices)")
+	gpstype := flag.String("gps-type", "none", "Configure the GPS: none, ublox, mtk or quectel")
+	gpsrate := flag.Int("gps-rate", 5, "Fix rate (Hz) for -gps-type, 0 = receiver default")
+	gnss := flag.String("gps-gnss", "", "Constellations for -gps-type (comma separated gps, glonass, galileo, beidou)")
+	ubxlegacy := flag.Bool("gps-ubx-legacy", false, "UBX NAV-POSLLH/VELNED/SOL rather than NAV-PVT (e.g. Neo-6M)")
+	mspdev := flag.String("msp", "", "MSP (FC) device")
+	mspbaud := flag.Int("msp-baud", 115200, "MSP baud rate")
+	mspvers := flag.Uint("msp-version", 0, "MSP version (0 = auto, 1 = MSPv1, 2 = MSPv2)")
+	flag.IntVar(&cfg.MspRetries, "msp-retries", cfg.MspRetries, "MSP request retries")
+	flag.DurationVar(&cfg.MspTimeout, "msp-timeout", 500*time.Millisecond, "MSP reply timeout")
+	flag.DurationVar(&cfg.MspWpTimeout, "msp-wp-timeout", time.Second, "MSP_SET_WP / MSP_WP reply timeout, 0 = -msp-timeout")
+	minsat := flag.Uint("min-sats", 6, "Minimum user sats for follow me")
+	flag.BoolVar(&cfg.ResetHome, "reset-home", false, "Also set the home location to the user")
+	flag.BoolVar(&cfg.WpVerify, "wp-verify", false, "Read back and verify waypoints")
+	mode := flag.Int("follow-mode", 0, "Follow position (0 = user, 1 = fixed, 2 = course relative, 3 = chase)")
+	dist := flag.Float64("follow-dist", 10, "Follow distance (m)")
+	brg := flag.Float64("follow-bearing", 180, "Follow bearing (deg)")
+	flag.BoolVar(&cfg.KfEnable, "kf", false, "Kalman filter the user's position")
+	kfhdop := flag.Float64("kf-max-hdop", 5.0, "Filter rejects fixes with a greater HDOP")
+	horizon := flag.Float64("lead-horizon", 1.0, "Lead time (s) in addition to the MSP latency")
+	leadmax := flag.Float64("lead-max", 0, "Maximum lead (m), 0 disables prediction")
+	frange := flag.Float64("fence-range", 0, "Maximum follow distance from home (m), 0 disables")
+	fstep := flag.Float64("fence-step", 0, "Maximum follow position step (m), 0 disables")
+	altmode := flag.Int("alt-mode", 0, "Altitude (0 = hold, 1 = above user, 2 = relative)")
+	altoff := flag.Float64("alt-offset", 10, "Height above the user (m), mode 1")
+	altmin := flag.Float64("alt-min", 5, "Minimum height above the user (m)")
+	altmax := flag.Float64("alt-max", 50, "Maximum height above the user (m)")
+	plain := flag.Bool("plain", false, "Log to stdout, no status display")
+	logname := flag.String("log", "", "Log file")
+	flag.Parse()
+
+	if *gpsdev == "" || *mspdev == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	cfg.MspVersion = byte(*mspvers)
+	cfg.MinSat = uint8(*minsat)
+	cfg.FollowMode = int32(*mode)
+	cfg.FollowDist = float32(*dist)
+	cfg.FollowBearing = float32(*brg)
+	cfg.KfMaxHdop = float32(*kfhdop)
+	cfg.LeadHorizon = float32(*horizon)
+	cfg.LeadMax = float32(*leadmax)
+	cfg.FenceRange = float32(*frange)
+	cfg.FenceStep = float32(*fstep)
+	cfg.AltMode = int32(*altmode)
+	cfg.AltOffset = float32(*altoff)
+	cfg.AltMin = float32(*altmin)
+	cfg.AltMax = float32(*altmax)
+
+	rx := gps.Receiver{Baud: uint32(*gpsbaud), Rate: *gpsrate, PVT: !*ubxlegacy, Model: gps.DYN_PEDESTRIAN}
+	var err error
+	if rx.Type, err = gps.ParseReceiver(*gpstype); err != nil {
+		log.Fatal(err)
+	}
+	if rx.GNSS, err = parseGNSS(*gnss); err != nil {
+		log.Fatal(err)
+	}
+
+	gp, err := transport.Open(*gpsdev, *gpsbaud)
+	if err != nil {
+		log.Fatalf("GPS %s: %v\n", *gpsdev, err)
+	}
+	defer gp.Close()
+	mp, err := transport.Open(*mspdev, *mspbaud)
+	if err != nil {
+		log.Fatalf("MSP %s: %v\n", *mspdev, err)
+	}
+	defer mp.Close()
+	showName("GPS", gp)
+	showName("MSP", mp)
+	if _, ok := gp.(msp.BaudSetter); *autobaud && !ok {
+		log.Printf("GPS %s: no baud rate, -gps-autobaud ignored\n", *gpsdev)
+		*autobaud = false
+	}
+
+	var lw io.Writer
+	if *logname != "" {
+		lf, err := os.Create(*logname)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer lf.Close()
+		lw = lf
+	}
+	t := NewTUI(os.Stdout, lw, *plain)
+	defer t.Close()
+
+	fchan := make(chan gps.Fix)
+	mchan := make(chan msp.MSPMsg)
+	schan := make(chan gps.Status)
+	echan := make(chan error, 2)
+	go gpsReader(gp, *autobaud, rx, fchan, schan, echan)
+	m := msp.NewMSPReader(mp, mchan)
+	go func() {
+		echan <- m.Reader()
+	}()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+
+	t.SplashScreen(VERSION)
+	fm := follow.NewFollower(cfg, m, clock{}, t, t)
+	ticker := time.NewTicker(follow.TICK)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			fm.Tick()
+		case fix := <-fchan:
+			fm.Fix(fix)
+		case st := <-schan:
+			t.Log("GPS " + st.Text)
+		case v := <-mchan:
+			fm.Message(v)
+		case err := <-echan:
+			t.Close()
+			log.Fatalf("Read error: %v\n", err)
+		case <-sig:
+			return
+		}
+		t.Refresh()
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+/* Terminal replacement for the OLED; the same fields, plus the most recent
+ * log messages below them */
+
+const (
+	row_TIME = iota
+	row_GPS
+	row_MODE
+	row_INAV
+	row_VSAT
+	row_VPOS
+	row_COUNT
+)
+
+const LOG_LINES = 12
+
+type TUI struct {
+	out     io.Writer
+	logfile io.Writer
+	plain   bool
+	dirty   bool
+	vers    string
+	imode   string
+	pos     string
+	flag    byte
+	rows    [row_COUNT]string
+	logs    []string
+}
+
+// NewTUI draws on out; in plain mode only log messages are written.
+// logfile, if not nil, receives time stamped log messages.
+func NewTUI(out io.Writer, logfile io.Writer, plain bool) *TUI {
+	t := &TUI{out: out, logfile: logfile, plain: plain, vers: "-.-.-", flag: ' '}
+	if !plain {
+		fmt.Fprint(out, "\033[2J\033[?25l")
+	}
+	return t
+}
+
+func (t *TUI) SplashScreen(version string) {
+	t.rows[row_TIME] = "INAV Follow Me! " + version
+	t.dirty = true
+}
+
+func (t *TUI) InitScreen(vb bool) {
+	t.ClearTime(false)
+	t.rows[row_GPS] = ""
+	t.rows[row_MODE] = ""
+	t.rows[row_VSAT] = ""
+	t.setINAV()
+	t.ClearINAVPos()
+}
+
+func (t *TUI) ClearTime(fail bool) {
+	if fail {
+		t.ShowTime("??:??:??")
+	} else {
+		t.ShowTime("--:--:--")
+	}
+}
+
+func (t *TUI) ShowTime(s string) {
+	t.rows[row_TIME] = s
+	t.dirty = true
+}
+
+func (t *TUI) ShowGPS(nsat uint16, fix uint8) {
+	s := sats(nsat)
+	switch fix {
+	case 0:
+		s += "NoFix"
+	case 1:
+		s += "Fix"
+	case 2:
+		s += "DFix"
+	}
+	t.rows[row_GPS] = s
+	t.dirty = true
+}
+
+func (t *TUI) ShowINAVVers(s string) {
+	t.vers = s
+	t.setINAV()
+}
+
+func (t *TUI) ShowMode(amode int16, imode int16) {
+	switch amode {
+	case 0:
+		t.rows[row_MODE] = "Starting"
+	case 1:
+		t.rows[row_MODE] = "Initialised"
+	case 2:
+		t.rows[row_MODE] = "Connecting"
+	case 3:
+		t.rows[row_MODE] = "Connected"
+	default:
+		t.rows[row_MODE] = "Failed"
+	}
+	switch imode {
+	case 0:
+		t.imode = "Idle"
+	case 1:
+		t.imode = "PH"
+	case 2:
+		t.imode = "RTH"
+	case 3:
+		t.imode = "WP"
+	default:
+		t.imode = "---"
+	}
+	t.setINAV()
+}
+
+func (t *TUI) ShowINAVSats(nsat uint16, hdop uint16) {
+	t.rows[row_VSAT] = sats(nsat) + strconv.FormatFloat(float64(hdop)/100, 'f', 1, 32)
+	t.dirty = true
+}
+
+func (t *TUI) ShowINAVPos(dist uint, brg uint16) {
+	if dist >= 100000 {
+		t.pos = ">100k"
+	} else if dist >= 10000 {
+		t.pos = strconv.FormatFloat(float64(dist)/1000, 'f', 1, 32) + "k"
+	} else {
+		t.pos = strconv.FormatUint(uint64(dist), 10) + "m"
+	}
+	t.pos += fmt.Sprintf(" %03d°", brg)
+	t.setPos()
+}
+
+func (t *TUI) ShowFenceHold(s string) {
+	t.pos = "!" + s
+	t.flag = ' '
+	t.setPos()
+}
+
+func (t *TUI) ShowFenceFlag(c byte) {
+	t.flag = c
+	t.setPos()
+}
+
+func (t *TUI) ClearINAVPos() {
+	t.pos = ""
+	t.flag = ' '
+	t.setPos()
+}
+
+func (t *TUI) ClearINAVSats() {
+	t.rows[row_VSAT] = ""
+	t.dirty = true
+}
+
+func (t *TUI) INAVReset() {
+	t.vers = "?.?.?"
+	t.imode = ""
+	t.rows[row_MODE] = ""
+	t.rows[row_VSAT] = ""
+	t.setINAV()
+	t.ClearINAVPos()
+}
+
+func (t *TUI) Log(s string) {
+	if t.logfile != nil {
+		fmt.Fprintln(t.logfile, time.Now().Format("15:04:05.000"), s)
+	}
+	if t.plain {
+		fmt.Fprintln(t.out, s)
+		return
+	}
+	t.logs = append(t.logs, s)
+	if len(t.logs) > LOG_LINES {
+		t.logs = t.logs[len(t.logs)-LOG_LINES:]
+	}
+	t.dirty = true
+}
+
+// Refresh redraws the screen if anything has changed
+func (t *TUI) Refresh() {
+	if t.plain || !t.dirty {
+		return
+	}
+	labels := [row_COUNT]string{"", "GPS :", "Mode:", "INAV:", "VSat:", "VPos:"}
+	fmt.Fprint(t.out, "\033[H")
+	for j, r := range t.rows {
+		if j == row_TIME {
+			fmt.Fprintf(t.out, "%s\033[K\r\n\033[K\r\n", r)
+		} else {
+			fmt.Fprintf(t.out, "%s %s\033[K\r\n", labels[j], r)
+		}
+	}
+	fmt.Fprint(t.out, "\033[K\r\n")
+	for _, l := range t.logs {
+		fmt.Fprintf(t.out, "%s\033[K\r\n", l)
+	}
+	fmt.Fprint(t.out, "\033[J")
+	t.dirty = false
+}
+
+// Close restores the terminal
+func (t *TUI) Close() {
+	if !t.plain {
+		fmt.Fprint(t.out, "\033[?25h\r\n")
+	}
+}
+
+func (t *TUI) setINAV() {
+	t.rows[row_INAV] = t.vers + " " + t.imode
+	t.dirty = true
+}
+
+func (t *TUI) setPos() {
+	t.rows[row_VPOS] = t.pos + " " + string(t.flag)
+	t.dirty = true
+}
+
+func sats(nsat uint16) string {
+	s := strconv.FormatUint(uint64(nsat), 10)
+	if nsat < 2 {
+		return s + " sat "
+	}
+	return s + " sats "
+}
@@ -3,6 +3,9 @@ module gpstest
 go 1.19
 
 require (
+	fence v1.0.0
+	filter v1.0.0
+	follow v1.0.0
 	geo v1.0.0
 	gps v1.0.0
 	msp v1.0.0
@@ -13,6 +16,12 @@ require (
 
 require github.com/Nondzu/ssd1306_font v1.0.1 // indirect
 
+replace fence v1.0.0 => ./pkg/fence
+
+replace filter v1.0.0 => ./pkg/filter
+
+replace follow v1.0.0 => ./pkg/follow
+
 replace geo v1.0.0 => ./pkg/geo
 
 replace gps v1.0.0 => ./pkg/gps
@@ -0,0 +1,185 @@
+package fence
+
+import (
+	"geo"
+	"math"
+)
+
+type Point struct {
+	Lat float32
+	Lon float32
+}
+
+// Zone is either a circle (one point and Radius) or a polygon (three or
+// more points). Exclusion zones are keep out areas; if any inclusion zones
+// are defined, the follow position must be inside one of them. The
+// boundary belongs to the zone.
+type Zone struct {
+	Exclude bool
+	Radius  float32 // m, circles only
+	Points  []Point
+}
+
+// Check results; clamped positions are sent, withheld ones are not
+const (
+	FENCE_OK = iota
+	FENCE_CLAMP_RANGE
+	FENCE_CLAMP_STEP
+	FENCE_NO_HOME
+	FENCE_OUTSIDE
+	FENCE_EXCLUDED
+)
+
+type Fence struct {
+	Zones    []Zone
+	MaxRange float32 // m from home, 0 disables
+	MaxStep  float32 // m between successive positions, 0 disables
+	home     Point
+	homeSet  bool
+	last     Point
+	lastSet  bool
+	veh      Point
+	vehSet   bool
+}
+
+func NewFence(zones []Zone, maxrange, maxstep float32) *Fence {
+	return &Fence{Zones: zones, MaxRange: maxrange, MaxStep: maxstep}
+}
+
+func (f *Fence) SetHome(lat, lon float32) {
+	f.home = Point{lat, lon}
+	f.homeSet = true
+}
+
+func (f *Fence) HomeSet() bool {
+	return f.homeSet
+}
+
+// SetVehicle records the vehicle's position; until a position is
+// committed, the step is measured from it
+func (f *Fence) SetVehicle(lat, lon float32) {
+	f.veh = Point{lat, lon}
+	f.vehSet = true
+}
+
+// Commit records a position sent to the FC, from which the next step is
+// measured
+func (f *Fence) Commit(lat, lon float32) {
+	f.last = Point{lat, lon}
+	f.lastSet = true
+}
+
+// Reset forgets the home, vehicle and last positions (e.g. on
+// reconnection)
+func (f *Fence) Reset() {
+	f.homeSet = false
+	f.lastSet = false
+	f.vehSet = false
+}
+
+func (z *Zone) contains(p Point) bool {
+	if len(z.Points) == 1 {
+		_, d := geo.Csedist(z.Points[0].Lat, z.Points[0].Lon, p.Lat, p.Lon)
+		return d <= z.Radius
+	}
+	// ray casting, adequate for the small areas of interest
+	in := false
+	n := len(z.Points)
+	for i, j := 0, n-1; i < n; j, i = i, i+1 {
+		a := z.Points[i]
+		b := z.Points[j]
+		if onEdge(a, b, p) {
+			return true
+		}
+		if (a.Lat > p.Lat) != (b.Lat > p.Lat) &&
+			p.Lon < (b.Lon-a.Lon)*(p.Lat-a.Lat)/(b.Lat-a.Lat)+a.Lon {
+			in = !in
+		}
+	}
+	return in
+}
+
+// onEdge returns true if p is on the segment ab, to about 0.1m
+func onEdge(a, b, p Point) bool {
+	const eps = 1e-6
+	ax, ay := float64(a.Lon), float64(a.Lat)
+	bx, by := float64(b.Lon), float64(b.Lat)
+	px, py := float64(p.Lon), float64(p.Lat)
+	if px < math.Min(ax, bx)-eps || px > math.Max(ax, bx)+eps ||
+		py < math.Min(ay, by)-eps || py > math.Max(ay, by)+eps {
+		return false
+	}
+	cross := (bx-ax)*(py-ay) - (by-ay)*(px-ax)
+	return math.Abs(cross) <= eps*math.Hypot(bx-ax, by-ay)
+}
+
+// Check applies the fence to a follow position, returning the (possibly
+// clamped) position and the result. For FENCE_NO_HOME, FENCE_OUTSIDE and
+// FENCE_EXCLUDED the position should not be sent; a position that is sent
+// should be passed to Commit.
+func (f *Fence) Check(lat, lon float32) (float32, float32, int) {
+	res := FENCE_OK
+	if f.MaxRange > 0 {
+		if !f.homeSet {
+			return lat, lon, FENCE_NO_HOME
+		}
+		c, d := geo.Csedist(f.home.Lat, f.home.Lon, lat, lon)
+		if d > f.MaxRange {
+			lat, lon = geo.Destination(f.home.Lat, f.home.Lon, c, f.MaxRange)
+			res = FENCE_CLAMP_RANGE
+		}
+	}
+	if from, ok := f.stepFrom(); f.MaxStep > 0 && ok {
+		c, d := geo.Csedist(from.Lat, from.Lon, lat, lon)
+		if d > f.MaxStep {
+			lat, lon = geo.Destination(from.Lat, from.Lon, c, f.MaxStep)
+			res = FENCE_CLAMP_STEP
+		}
+	}
+	p := Point{lat, lon}
+	inc := false
+	hasinc := false
+	for i := range f.Zones {
+		z := &f.Zones[i]
+		if z.Exclude {
+			if z.contains(p) {
+				return lat, lon, FENCE_EXCLUDED
+			}
+		} else {
+			hasinc = true
+			if !inc && z.contains(p) {
+				inc = true
+			}
+		}
+	}
+	if hasinc && !inc {
+		return lat, lon, FENCE_OUTSIDE
+	}
+	return lat, lon, res
+}
+
+// stepFrom returns the position the step is limited from
+func (f *Fence) stepFrom() (Point, bool) {
+	if f.lastSet {
+		return f.last, true
+	}
+	return f.veh, f.vehSet
+}
+
+// Reason returns a short description of a Check result
+func Reason(res int) string {
+	switch res {
+	case FENCE_CLAMP_RANGE:
+		return "Range"
+	case FENCE_CLAMP_STEP:
+		return "Step"
+	case FENCE_NO_HOME:
+		return "NoHome"
+	case FENCE_OUTSIDE:
+		return "Outside"
+	case FENCE_EXCLUDED:
+		return "KeepOut"
+	default:
+		return "OK"
+	}
+}
@@ -0,0 +1,169 @@
+package fence
+
+import (
+	"geo"
+	"testing"
+)
+
+const hlat, hlon = 51.5, -0.1
+
+// at returns the point dist metres from home on bearing brg
+func at(brg, dist float32) Point {
+	lat, lon := geo.Destination(hlat, hlon, brg, dist)
+	return Point{lat, lon}
+}
+
+func dist(a Point, lat, lon float32) float32 {
+	_, d := geo.Csedist(a.Lat, a.Lon, lat, lon)
+	return d
+}
+
+func TestNoHome(t *testing.T) {
+	f := NewFence(nil, 100, 0)
+	p := at(0, 10)
+	lat, lon, res := f.Check(p.Lat, p.Lon)
+	if res != FENCE_NO_HOME || lat != p.Lat || lon != p.Lon {
+		t.Errorf("got %s, want NoHome", Reason(res))
+	}
+	f.SetHome(hlat, hlon)
+	if _, _, res = f.Check(p.Lat, p.Lon); res != FENCE_OK {
+		t.Errorf("with home got %s, want OK", Reason(res))
+	}
+	f.Reset()
+	if _, _, res = f.Check(p.Lat, p.Lon); res != FENCE_NO_HOME {
+		t.Errorf("after reset got %s, want NoHome", Reason(res))
+	}
+	// without a range limit, home is not required
+	f = NewFence(nil, 0, 0)
+	if _, _, res = f.Check(p.Lat, p.Lon); res != FENCE_OK {
+		t.Errorf("no range got %s, want OK", Reason(res))
+	}
+}
+
+func TestRangeClamp(t *testing.T) {
+	tests := []struct {
+		name string
+		d    float32
+		res  int
+		want float32 // distance from home
+	}{
+		{"inside", 80, FENCE_OK, 80},
+		{"at limit", 99.9, FENCE_OK, 99.9},
+		{"beyond", 150, FENCE_CLAMP_RANGE, 100},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f := NewFence(nil, 100, 0)
+			f.SetHome(hlat, hlon)
+			p := at(60, tc.d)
+			lat, lon, res := f.Check(p.Lat, p.Lon)
+			if res != tc.res {
+				t.Errorf("got %s, want %s", Reason(res), Reason(tc.res))
+			}
+			home := Point{hlat, hlon}
+			if d := dist(home, lat, lon); d < tc.want-0.5 || d > tc.want+0.5 {
+				t.Errorf("%.1fm from home, want %.1fm", d, tc.want)
+			}
+			if c, _ := geo.Csedist(hlat, hlon, lat, lon); c < 59 || c > 61 {
+				t.Errorf("bearing %.1f, want 60", c)
+			}
+		})
+	}
+}
+
+func TestStepClamp(t *testing.T) {
+	f := NewFence(nil, 0, 20)
+	p := at(0, 0)
+	// nothing to measure from
+	if _, _, res := f.Check(p.Lat, p.Lon); res != FENCE_OK {
+		t.Fatalf("first position %s, want OK", Reason(res))
+	}
+	f.Commit(p.Lat, p.Lon)
+	p1 := at(0, 15)
+	lat, lon, res := f.Check(p1.Lat, p1.Lon)
+	if res != FENCE_OK || lat != p1.Lat || lon != p1.Lon {
+		t.Errorf("15m step %s, want OK", Reason(res))
+	}
+	f.Commit(lat, lon)
+	p2 := at(0, 65)
+	lat, lon, res = f.Check(p2.Lat, p2.Lon)
+	if res != FENCE_CLAMP_STEP {
+		t.Errorf("50m step %s, want Step", Reason(res))
+	}
+	if d := dist(p1, lat, lon); d < 19.5 || d > 20.5 {
+		t.Errorf("stepped %.1fm, want 20m", d)
+	}
+	// positions not sent do not move the start of the step
+	lat, lon, _ = f.Check(p2.Lat, p2.Lon)
+	if d := dist(p1, lat, lon); d < 19.5 || d > 20.5 {
+		t.Errorf("uncommitted step: %.1fm from the last sent", d)
+	}
+	// the clamped position sent is the start of the next step
+	f.Commit(lat, lon)
+	lat, lon, res = f.Check(p2.Lat, p2.Lon)
+	if res != FENCE_CLAMP_STEP || dist(p1, lat, lon) < 39.5 {
+		t.Errorf("second step %s, %.1fm from the first", Reason(res), dist(p1, lat, lon))
+	}
+}
+
+func TestStepFromVehicle(t *testing.T) {
+	f := NewFence(nil, 0, 20)
+	f.Commit(0, 0)
+	f.Reset()
+	v := at(0, 100)
+	f.SetVehicle(v.Lat, v.Lon)
+	p := at(0, 0)
+	lat, lon, res := f.Check(p.Lat, p.Lon)
+	if d := dist(v, lat, lon); res != FENCE_CLAMP_STEP || d < 19.5 || d > 20.5 {
+		t.Errorf("first position after reset %s, %.1fm from the vehicle", Reason(res), d)
+	}
+	// once a position is sent, the step is from it
+	f.Commit(lat, lon)
+	f.SetVehicle(p.Lat, p.Lon)
+	c := at(0, 60)
+	if lat, lon, res = f.Check(p.Lat, p.Lon); res != FENCE_CLAMP_STEP || dist(c, lat, lon) > 0.5 {
+		t.Errorf("step %s, %.1fm from the expected", Reason(res), dist(c, lat, lon))
+	}
+}
+
+func TestZones(t *testing.T) {
+	// a 100m square centred on home; corners at 45, 135, 225, 315 degrees
+	var square []Point
+	for _, brg := range []float32{45, 135, 225, 315} {
+		square = append(square, at(brg, 70.71))
+	}
+	ne, se := square[0], square[1]
+	// on the east edge
+	edge := Point{(ne.Lat + se.Lat) / 2, (ne.Lon + se.Lon) / 2}
+	circle := []Point{at(0, 0)}
+
+	tests := []struct {
+		name  string
+		zones []Zone
+		p     Point
+		res   int
+	}{
+		{"no zones", nil, at(0, 200), FENCE_OK},
+		{"in polygon", []Zone{{Points: square}}, at(30, 20), FENCE_OK},
+		{"outside polygon", []Zone{{Points: square}}, at(90, 80), FENCE_OUTSIDE},
+		{"polygon edge", []Zone{{Points: square}}, edge, FENCE_OK},
+		{"polygon vertex", []Zone{{Points: square}}, ne, FENCE_OK},
+		{"in circle", []Zone{{Radius: 30, Points: circle}}, at(200, 25), FENCE_OK},
+		{"outside circle", []Zone{{Radius: 30, Points: circle}}, at(200, 35), FENCE_OUTSIDE},
+		{"any inclusion", []Zone{{Radius: 30, Points: circle}, {Points: square}}, at(90, 40), FENCE_OK},
+		{"excluded polygon", []Zone{{Exclude: true, Points: square}}, at(30, 20), FENCE_EXCLUDED},
+		{"excluded polygon edge", []Zone{{Exclude: true, Points: square}}, edge, FENCE_EXCLUDED},
+		{"clear of exclusion", []Zone{{Exclude: true, Points: square}}, at(90, 80), FENCE_OK},
+		{"excluded circle", []Zone{{Exclude: true, Radius: 30, Points: circle}}, at(200, 25), FENCE_EXCLUDED},
+		{"exclusion in inclusion", []Zone{{Points: square}, {Exclude: true, Radius: 30, Points: circle}}, at(200, 25), FENCE_EXCLUDED},
+		{"inclusion around exclusion", []Zone{{Points: square}, {Exclude: true, Radius: 30, Points: circle}}, at(200, 40), FENCE_OK},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f := NewFence(tc.zones, 0, 0)
+			if _, _, res := f.Check(tc.p.Lat, tc.p.Lon); res != tc.res {
+				t.Errorf("got %s, want %s", Reason(res), Reason(tc.res))
+			}
+		})
+	}
+}
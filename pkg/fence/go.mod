@@ -0,0 +1,7 @@
+module fence
+
+require geo v1.0.0
+
+replace geo v1.0.0 => ../geo
+
+go 1.19
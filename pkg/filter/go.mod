@@ -0,0 +1,7 @@
+module filter
+
+require gps v1.0.0
+
+replace gps v1.0.0 => ../gps
+
+go 1.19
@@ -0,0 +1,180 @@
+package filter
+
+import (
+	"gps"
+	"math"
+)
+
+const (
+	earth_RADIUS = 6371000.0
+	ms_TO_KNOTS  = 1.943844
+	// 1 sigma user equivalent range error (m) per unit of HDOP
+	UERE = 3.0
+	// Chi-squared (2 DOF, 99.9%) gate for the position innovation
+	GATE = 13.8
+	// After this many consecutive rejections the filter is restarted
+	MAX_REJECT = 5
+	// Maximum time (s) between fixes before the filter is restarted
+	MAX_GAP = 10.0
+)
+
+// axis is a constant velocity filter for one ENU axis; state [pos, vel]
+type axis struct {
+	x [2]float32
+	p [2][2]float32
+}
+
+func (a *axis) init(pos, pvar float32) {
+	a.x = [2]float32{pos, 0}
+	a.p = [2][2]float32{{pvar, 0}, {0, 25}}
+}
+
+func (a *axis) predict(dt, q float32) {
+	a.x[0] += dt * a.x[1]
+	p := a.p
+	a.p[0][0] = p[0][0] + dt*(p[1][0]+p[0][1]) + dt*dt*p[1][1] + q*dt*dt*dt/3
+	a.p[0][1] = p[0][1] + dt*p[1][1] + q*dt*dt/2
+	a.p[1][0] = p[1][0] + dt*p[1][1] + q*dt*dt/2
+	a.p[1][1] = p[1][1] + q*dt
+}
+
+func (a *axis) update(z, r float32) {
+	s := a.p[0][0] + r
+	k0 := a.p[0][0] / s
+	k1 := a.p[1][0] / s
+	y := z - a.x[0]
+	a.x[0] += k0 * y
+	a.x[1] += k1 * y
+	p := a.p
+	a.p[0][0] = (1 - k0) * p[0][0]
+	a.p[0][1] = (1 - k0) * p[0][1]
+	a.p[1][0] = p[1][0] - k1*p[0][0]
+	a.p[1][1] = p[1][1] - k1*p[0][1]
+}
+
+// Cov holds the estimated variances (m², (m/s)²) of the filtered fix
+type Cov struct {
+	E  float32
+	N  float32
+	VE float32
+	VN float32
+}
+
+// Kalman smooths user fixes with a constant velocity model in a local
+// East / North frame centred on the first accepted fix.
+type Kalman struct {
+	Accel   float32 // process noise, m/s² (1 sigma)
+	MaxHdop float32
+	MinSats uint8
+	e, n    axis
+	lat0    float64
+	lon0    float64
+	coslat  float64
+	last    gps.Fix
+	valid   bool
+	reject  int
+}
+
+func NewKalman(accel, maxhdop float32, minsats uint8) *Kalman {
+	return &Kalman{Accel: accel, MaxHdop: maxhdop, MinSats: minsats}
+}
+
+func (k *Kalman) Reset() {
+	k.valid = false
+	k.reject = 0
+}
+
+func (k *Kalman) toLocal(lat, lon float32) (float32, float32) {
+	dn := (float64(lat) - k.lat0) * math.Pi / 180 * earth_RADIUS
+	de := (float64(lon) - k.lon0) * math.Pi / 180 * earth_RADIUS * k.coslat
+	return float32(de), float32(dn)
+}
+
+func (k *Kalman) toGeo(e, n float32) (float32, float32) {
+	lat := k.lat0 + float64(n)/earth_RADIUS*180/math.Pi
+	lon := k.lon0 + float64(e)/(earth_RADIUS*k.coslat)*180/math.Pi
+	return float32(lat), float32(lon)
+}
+
+func (k *Kalman) mvar(fix gps.Fix) float32 {
+	h := fix.Hdop
+	if h <= 0 {
+		h = 2
+	}
+	sd := h * UERE
+	return sd * sd
+}
+
+func (k *Kalman) start(fix gps.Fix) {
+	k.lat0 = float64(fix.Lat)
+	k.lon0 = float64(fix.Lon)
+	k.coslat = math.Cos(k.lat0 * math.Pi / 180)
+	r := k.mvar(fix)
+	k.e.init(0, r)
+	k.n.init(0, r)
+	k.last = fix
+	k.valid = true
+	k.reject = 0
+}
+
+// Update filters a fix. It returns the smoothed fix (Lat, Lon, Spd (knots)
+// and Hdg replaced, VALID_VEL set), its covariance and false if the fix was rejected as an
+// outlier, in which case the current estimate is returned.
+func (k *Kalman) Update(fix gps.Fix) (gps.Fix, Cov, bool) {
+	if fix.Quality == 0 || fix.Sats < k.MinSats || (k.MaxHdop > 0 && fix.Hdop > k.MaxHdop) {
+		if !k.valid {
+			return fix, Cov{}, false
+		}
+		return k.output(fix), k.cov(), false
+	}
+	if !k.valid {
+		k.start(fix)
+		return k.output(fix), k.cov(), true
+	}
+
+	dt := float32(fix.Sub(k.last).Seconds())
+	if dt < 0 || dt > MAX_GAP || k.reject >= MAX_REJECT {
+		k.start(fix)
+		return k.output(fix), k.cov(), true
+	}
+	if dt > 0 {
+		q := k.Accel * k.Accel
+		k.e.predict(dt, q)
+		k.n.predict(dt, q)
+		k.last.Stamp = fix.Stamp
+		k.last.Valid = fix.Valid
+	}
+
+	ze, zn := k.toLocal(fix.Lat, fix.Lon)
+	r := k.mvar(fix)
+	ye := ze - k.e.x[0]
+	yn := zn - k.n.x[0]
+	d2 := ye*ye/(k.e.p[0][0]+r) + yn*yn/(k.n.p[0][0]+r)
+	if d2 > GATE {
+		k.reject++
+		return k.output(fix), k.cov(), false
+	}
+	k.reject = 0
+	k.e.update(ze, r)
+	k.n.update(zn, r)
+	k.last = fix
+	return k.output(fix), k.cov(), true
+}
+
+func (k *Kalman) output(fix gps.Fix) gps.Fix {
+	fix.Lat, fix.Lon = k.toGeo(k.e.x[0], k.n.x[0])
+	ve := float64(k.e.x[1])
+	vn := float64(k.n.x[1])
+	fix.Spd = float32(math.Sqrt(ve*ve+vn*vn)) * ms_TO_KNOTS
+	hdg := math.Atan2(ve, vn) * 180 / math.Pi
+	if hdg < 0 {
+		hdg += 360
+	}
+	fix.Hdg = float32(hdg)
+	fix.Valid |= gps.VALID_VEL
+	return fix
+}
+
+func (k *Kalman) cov() Cov {
+	return Cov{E: k.e.p[0][0], N: k.n.p[0][0], VE: k.e.p[1][1], VN: k.n.p[1][1]}
+}
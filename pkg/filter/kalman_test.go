@@ -0,0 +1,204 @@
+package filter
+
+import (
+	"gps"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+const lat0, lon0 = 51.5, -0.1
+
+var t0 = time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+
+// fixAt returns a fix e, n metres from (lat0, lon0) at t0 + s seconds
+func fixAt(s float64, e, n float64) gps.Fix {
+	lat := lat0 + n/earth_RADIUS*180/math.Pi
+	lon := lon0 + e/(earth_RADIUS*math.Cos(lat0*math.Pi/180))*180/math.Pi
+	return gps.Fix{Quality: 1, Sats: 10, Hdop: 1, Lat: float32(lat), Lon: float32(lon),
+		Stamp: t0.Add(time.Duration(s * float64(time.Second))),
+		Valid: gps.VALID_TIME | gps.VALID_DATE | gps.VALID_POS | gps.VALID_SATS}
+}
+
+// offset returns the east, north metres of a fix from (lat0, lon0)
+func offset(f gps.Fix) (float64, float64) {
+	n := (float64(f.Lat) - lat0) * math.Pi / 180 * earth_RADIUS
+	e := (float64(f.Lon) - lon0) * math.Pi / 180 * earth_RADIUS * math.Cos(lat0*math.Pi/180)
+	return e, n
+}
+
+func TestKalmanSmoothing(t *testing.T) {
+	// walking north at 1.5m/s, 3m (HDOP 1) of noise
+	rnd := rand.New(rand.NewSource(1))
+	k := NewKalman(0.5, 0, 6)
+	var raw, filt float64
+	var out gps.Fix
+	for j := 0; j < 60; j++ {
+		n := 1.5 * float64(j)
+		fix := fixAt(float64(j), UERE*rnd.NormFloat64(), n+UERE*rnd.NormFloat64())
+		var ok bool
+		out, _, ok = k.Update(fix)
+		if !ok {
+			t.Fatalf("fix %d rejected", j)
+		}
+		if j >= 30 {
+			e, fn := offset(fix)
+			raw += e*e + (fn-n)*(fn-n)
+			e, fn = offset(out)
+			filt += e*e + (fn-n)*(fn-n)
+		}
+	}
+	if filt >= raw/2 {
+		t.Errorf("filtered error %.1fm², raw %.1fm²", filt/30, raw/30)
+	}
+	if spd := out.Spd / ms_TO_KNOTS; math.Abs(float64(spd)-1.5) > 0.5 {
+		t.Errorf("speed %.2fm/s, want 1.5m/s", spd)
+	}
+	if hdg := out.Hdg; hdg > 20 && hdg < 340 {
+		t.Errorf("heading %.0f, want 0", hdg)
+	}
+	if out.Valid&gps.VALID_VEL == 0 {
+		t.Error("VALID_VEL not set")
+	}
+}
+
+func TestKalmanOutlier(t *testing.T) {
+	k := NewKalman(0.5, 0, 6)
+	for j := 0; j < 10; j++ {
+		k.Update(fixAt(float64(j), 0, float64(j)))
+	}
+	out, _, ok := k.Update(fixAt(10, 200, 10))
+	if ok {
+		t.Fatal("200m jump accepted")
+	}
+	if e, n := offset(out); math.Hypot(e, n-10) > 3 {
+		t.Errorf("estimate moved to %.1f, %.1f", e, n)
+	}
+	if _, _, ok := k.Update(fixAt(11, 0, 11)); !ok {
+		t.Error("fix after the outlier rejected")
+	}
+}
+
+func TestKalmanNoise(t *testing.T) {
+	tests := []struct {
+		name string
+		hdop float32
+		sats uint8
+		ok   bool
+		r    float32
+	}{
+		{"hdop 1", 1, 10, true, UERE * UERE},
+		{"hdop 4", 4, 10, true, 16 * UERE * UERE},
+		{"no hdop", 0, 10, true, 4 * UERE * UERE},
+		{"too few sats", 1, 5, false, 0},
+		{"hdop over max", 6, 10, false, 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			k := NewKalman(0.5, 5, 6)
+			fix := fixAt(0, 0, 0)
+			fix.Hdop = tc.hdop
+			fix.Sats = tc.sats
+			_, cov, ok := k.Update(fix)
+			if ok != tc.ok {
+				t.Fatalf("accepted %v, want %v", ok, tc.ok)
+			}
+			if cov.E != tc.r || cov.N != tc.r {
+				t.Errorf("variance %.1f, %.1f, want %.1f", cov.E, cov.N, tc.r)
+			}
+		})
+	}
+
+	// a step is followed less closely at a higher HDOP
+	step := func(hdop float32) float64 {
+		k := NewKalman(0.5, 0, 6)
+		for j := 0; j < 10; j++ {
+			k.Update(fixAt(float64(j), 0, 0))
+		}
+		fix := fixAt(10, 0, 5)
+		fix.Hdop = hdop
+		out, _, _ := k.Update(fix)
+		_, n := offset(out)
+		return n
+	}
+	if lo, hi := step(1), step(3); hi >= lo {
+		t.Errorf("moved %.2fm at HDOP 3, %.2fm at HDOP 1", hi, lo)
+	}
+}
+
+func TestKalmanRestart(t *testing.T) {
+	track := func() *Kalman {
+		k := NewKalman(0.5, 0, 6)
+		for j := 0; j < 10; j++ {
+			k.Update(fixAt(float64(j), 0, 1.5*float64(j)))
+		}
+		return k
+	}
+
+	k := track()
+	for j := 0; j < MAX_REJECT; j++ {
+		if _, _, ok := k.Update(fixAt(float64(10+j), 500, 0)); ok {
+			t.Fatalf("reject %d accepted", j)
+		}
+	}
+	out, cov, ok := k.Update(fixAt(10+MAX_REJECT, 500, 0))
+	if !ok {
+		t.Fatal("not restarted after MAX_REJECT rejects")
+	}
+	if e, n := offset(out); math.Hypot(e-500, n) > 1 || out.Spd != 0 {
+		t.Errorf("restarted at %.1f, %.1f, speed %.1f", e, n, out.Spd)
+	}
+	if cov.E != UERE*UERE {
+		t.Errorf("variance %.1f after restart", cov.E)
+	}
+
+	k = track()
+	out, _, ok = k.Update(fixAt(9+MAX_GAP+1, 0, 100))
+	if !ok {
+		t.Fatal("not restarted after MAX_GAP")
+	}
+	if _, n := offset(out); math.Abs(n-100) > 1 || out.Spd != 0 {
+		t.Errorf("restarted at %.1fm north, speed %.1f", n, out.Spd)
+	}
+}
+
+func TestKalmanMidnight(t *testing.T) {
+	tests := []struct {
+		name string
+		day  time.Time
+	}{
+		{"dated", time.Date(2026, 10, 17, 23, 59, 50, 0, time.UTC)},
+		{"time of day", time.Date(0, 0, 0, 23, 59, 50, 0, time.UTC)},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// driving north at 10m/s
+			k := NewKalman(0.5, 0, 6)
+			var out gps.Fix
+			for j := 0; j < 20; j++ {
+				fix := fixAt(0, 0, 10*float64(j))
+				fix.Stamp = tc.day.Add(time.Duration(j) * time.Second)
+				if tc.day.Year() <= 0 {
+					// time of day only, wrapping at midnight
+					fix.Stamp = time.Date(0, 0, 0, fix.Stamp.Hour(), fix.Stamp.Minute(), fix.Stamp.Second(), 0, time.UTC)
+					fix.Valid &^= gps.VALID_DATE
+				}
+				var ok bool
+				if out, _, ok = k.Update(fix); !ok {
+					t.Fatalf("fix %d rejected", j)
+				}
+				if j == 10 {
+					// without the prediction over dt, the estimate lags
+					if _, n := offset(out); math.Abs(n-100) > 2 {
+						t.Errorf("%.1fm north at midnight, want 100m", n)
+					}
+				}
+			}
+			// a restart at midnight would have lost the velocity
+			if spd := out.Spd / ms_TO_KNOTS; math.Abs(float64(spd)-10) > 1 {
+				t.Errorf("speed %.2fm/s after midnight, want 10m/s", spd)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package follow
+
+/* Follow me altitude policies. The WP#255 altitude is either 0 (INAV keeps
+ * the current altitude) or a home relative altitude; INAV ignores P3 for
+ * WP#255, so the user's GPS (AMSL) altitude is applied as an offset to the
+ * vehicle's home relative altitude */
+
+const (
+	ALT_HOLD     = iota // keep vehicle altitude
+	ALT_AGL             // fixed height above the user
+	ALT_RELATIVE        // height above the user at engage time
+)
+
+type AltPolicy struct {
+	Mode    int32
+	Offset  float32 // ALT_AGL height above the user (m)
+	Min     float32 // clamp for height above the user (m), ignored if Max <= Min
+	Max     float32
+	ref     float32
+	engaged bool
+}
+
+// Engage captures the vehicle's height above the user for ALT_RELATIVE
+func (a *AltPolicy) Engage(user, vehicle float32) {
+	a.ref = vehicle - user
+	a.engaged = true
+}
+
+func (a *AltPolicy) Disengage() {
+	a.engaged = false
+}
+
+func (a *AltPolicy) Engaged() bool {
+	return a.engaged
+}
+
+// Target returns the home relative WP altitude (cm), 0 to keep the current
+// altitude. user and vehicle are AMSL, vrel is the vehicle's altitude
+// relative to home (m).
+func (a *AltPolicy) Target(user, vehicle, vrel float32) int32 {
+	var h float32
+	switch a.Mode {
+	case ALT_AGL:
+		h = a.Offset
+	case ALT_RELATIVE:
+		if !a.engaged {
+			return 0
+		}
+		h = a.ref
+	default:
+		return 0
+	}
+	if a.Max > a.Min {
+		if h < a.Min {
+			h = a.Min
+		} else if h > a.Max {
+			h = a.Max
+		}
+	}
+	alt := int32((vrel + user + h - vehicle) * 100)
+	if alt == 0 {
+		alt = 1 // 0 would mean "keep altitude"
+	}
+	return alt
+}
@@ -0,0 +1,38 @@
+package follow
+
+import (
+	"testing"
+)
+
+func TestAltPolicy(t *testing.T) {
+	// a site at 300m AMSL, the vehicle 10m above home
+	const user, vehicle, vrel = 300.0, 310.0, 10.0
+	tests := []struct {
+		name string
+		a    AltPolicy
+		want int32
+	}{
+		{"hold", AltPolicy{Mode: ALT_HOLD}, 0},
+		{"agl", AltPolicy{Mode: ALT_AGL, Offset: 20}, 2000},
+		{"agl min", AltPolicy{Mode: ALT_AGL, Offset: 2, Min: 5, Max: 50}, 500},
+		{"agl max", AltPolicy{Mode: ALT_AGL, Offset: 80, Min: 5, Max: 50}, 5000},
+		{"relative not engaged", AltPolicy{Mode: ALT_RELATIVE}, 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.a.Target(user, vehicle, vrel); got != tc.want {
+				t.Errorf("got %d, want %d", got, tc.want)
+			}
+		})
+	}
+
+	a := AltPolicy{Mode: ALT_RELATIVE}
+	a.Engage(user, vehicle)
+	if got := a.Target(user, vehicle, vrel); got != 1000 {
+		t.Errorf("engaged: got %d, want 1000", got)
+	}
+	// the user climbs 5m
+	if got := a.Target(user+5, vehicle, vrel); got != 1500 {
+		t.Errorf("climb: got %d, want 1500", got)
+	}
+}
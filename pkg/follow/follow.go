@@ -0,0 +1,516 @@
+package follow
+
+/* The follow me state machine. Events (ticks, GPS fixes, MSP messages and
+ * configuration changes) are fed in by the caller; actions are MSP requests
+ * written to the transport, display updates and log messages. Nothing here
+ * depends on the hardware. */
+
+import (
+	"io"
+	"strconv"
+	"time"
+)
+
+import (
+	"fence"
+	"filter"
+	"geo"
+	"gps"
+	"msp"
+)
+
+// Timeouts, in ticks of TICK
+const (
+	TICK           = 100 * time.Millisecond
+	GPS_TIMEOUT    = 600
+	MSP_TIMEOUT    = 600
+	NAV_TIMEOUT    = 100
+	SPLASH_TIMEOUT = 50
+	HOME_RETRY     = 50 // between fence home requests
+)
+
+// MSP connection states
+const (
+	MSP_INIT_NONE = 0 + iota // splash screen
+	MSP_INIT_INIT            // waiting for a GPS fix
+	MSP_INIT_WIP             // identifying the FC
+	MSP_INIT_DONE            // following
+	MSP_INIT_FAIL            // vehicle type not followed
+)
+
+const (
+	HOME_WP   = 0
+	FOLLOW_WP = 255
+)
+
+type Clock interface {
+	Now() time.Time
+}
+
+type Display interface {
+	InitScreen(vb bool)
+	ShowMode(amode int16, imode int16)
+	ClearTime(fail bool)
+	ShowTime(t string)
+	ShowGPS(nsat uint16, fix uint8)
+	ShowINAVVers(t string)
+	ShowINAVSats(nsat uint16, hdop uint16)
+	ShowINAVPos(dist uint, brg uint16)
+	ShowFenceHold(t string)
+	ShowFenceFlag(c byte)
+	ClearINAVPos()
+	ClearINAVSats()
+	INAVReset()
+}
+
+type Logger interface {
+	Log(s string)
+}
+
+type Config struct {
+	UseVBat           bool
+	MinSat            uint8
+	DontFollowType    uint8
+	MinFollowDist     float32 // m
+	TimeFormat        string
+	ResetHome         bool
+	MspVersion        byte
+	MspRetries        int           // 0 = no retries (msp_retries = 0)
+	MspTimeout        time.Duration // 0 = msp.DEFAULT_TIMEOUT
+	MspWpTimeout      time.Duration // MSP_SET_WP and MSP_WP; 0 = MspTimeout
+	WpVerify          bool
+	WpVerifyRetries   int
+	WpVerifyTolerance float32 // m
+	FollowMode        int32
+	FollowDist        float32 // m
+	FollowBearing     float32 // deg
+	KfEnable          bool
+	KfMaxHdop         float32
+	KfAccel           float32 // m/s²
+	LeadHorizon       float32 // s
+	LeadMax           float32 // m
+	FenceZones        []fence.Zone
+	FenceRange        float32 // m
+	FenceStep         float32 // m
+	AltMode           int32
+	AltOffset         float32 // m
+	AltMin            float32 // m
+	AltMax            float32 // m
+}
+
+type Follower struct {
+	cfg      Config
+	link     *msp.Client
+	disp     Display
+	logger   Logger
+	state    int
+	mode     byte
+	vlat     float32
+	vlon     float32
+	valt     float32
+	vrel     float32 // altitude relative to home (m)
+	vrelok   bool
+	kf       *filter.Kalman
+	fnc      *fence.Fence
+	predict  geo.Predictor
+	pfix     gps.Fix // the previous fix projected
+	standoff geo.Standoff
+	altp     AltPolicy
+	mloop    int
+	ttick    int
+	gtick    int
+	mtick    int
+	htick    int // next fence home request
+	date     time.Time
+}
+
+// NewFollower returns a follower writing MSP requests to t; logger may be
+// nil.
+func NewFollower(cfg Config, t io.Writer, clock Clock, disp Display, logger Logger) *Follower {
+	f := &Follower{disp: disp, logger: logger, state: MSP_INIT_NONE}
+	f.link = msp.NewClient(t, clock.Now)
+	f.kf = filter.NewKalman(cfg.KfAccel, cfg.KfMaxHdop, cfg.MinSat)
+	f.fnc = fence.NewFence(cfg.FenceZones, cfg.FenceRange, cfg.FenceStep)
+	f.link.SetVersion(cfg.MspVersion)
+	f.link.SetVerify(cfg.WpVerify, cfg.WpVerifyRetries, cfg.WpVerifyTolerance)
+	f.cfg = cfg
+	f.SetConfig(cfg)
+	return f
+}
+
+// SetConfig applies a (CLI) configuration change
+func (f *Follower) SetConfig(c Config) {
+	if c.MspVersion != f.cfg.MspVersion {
+		f.link.SetVersion(c.MspVersion)
+	}
+	f.link.SetRetries(c.MspRetries)
+	// a zero timeout would fail every request at the next tick
+	if c.MspTimeout > 0 {
+		f.link.SetTimeout(c.MspTimeout)
+	} else {
+		f.link.SetTimeout(msp.DEFAULT_TIMEOUT)
+	}
+	f.link.SetCmdTimeout(msp.MSP_SET_WP, c.MspWpTimeout)
+	f.link.SetCmdTimeout(msp.MSP_WP, c.MspWpTimeout)
+	if c.WpVerify != f.cfg.WpVerify || c.WpVerifyRetries != f.cfg.WpVerifyRetries ||
+		c.WpVerifyTolerance != f.cfg.WpVerifyTolerance {
+		f.link.SetVerify(c.WpVerify, c.WpVerifyRetries, c.WpVerifyTolerance)
+	}
+	if c.KfEnable != f.cfg.KfEnable {
+		f.kf.Reset()
+	}
+	f.kf.Accel = c.KfAccel
+	f.kf.MaxHdop = c.KfMaxHdop
+	f.kf.MinSats = c.MinSat
+	f.predict.Horizon = c.LeadHorizon
+	f.predict.MaxLead = c.LeadMax
+	f.standoff.Mode = c.FollowMode
+	f.standoff.Dist = c.FollowDist
+	f.standoff.Bearing = c.FollowBearing
+	f.fnc.Zones = c.FenceZones
+	f.fnc.MaxRange = c.FenceRange
+	f.fnc.MaxStep = c.FenceStep
+	if c.AltMode != f.cfg.AltMode {
+		f.altp.Disengage()
+	}
+	f.altp.Mode = c.AltMode
+	f.altp.Offset = c.AltOffset
+	f.altp.Min = c.AltMin
+	f.altp.Max = c.AltMax
+	f.cfg = c
+}
+
+func (f *Follower) Config() Config {
+	return f.cfg
+}
+
+// State returns the MSP connection state (MSP_INIT_*)
+func (f *Follower) State() int {
+	return f.state
+}
+
+// Mode returns the INAV navigation mode (msp.NAV_MODE_*)
+func (f *Follower) Mode() byte {
+	return f.mode
+}
+
+// Link returns the MSP client, for statistics
+func (f *Follower) Link() *msp.Client {
+	return f.link
+}
+
+// Tick is called every TICK
+func (f *Follower) Tick() {
+	f.ttick += 1
+
+	if f.state == MSP_INIT_NONE {
+		if f.ttick == SPLASH_TIMEOUT {
+			f.log("Initialised")
+			f.disp.InitScreen(f.cfg.UseVBat)
+			f.state = MSP_INIT_INIT
+		}
+	} else if f.ttick%10 == 0 {
+		f.disp.ShowMode(int16(f.state), int16(f.mode))
+	}
+
+	if f.ttick-f.gtick > GPS_TIMEOUT {
+		f.log("*** GPS timeout ***")
+		f.gtick = f.ttick
+		f.disp.ClearTime(true)
+		f.disp.ShowGPS(0, 0)
+		f.disp.ClearINAVPos()
+	}
+
+	if f.state == MSP_INIT_WIP && f.ttick-f.mtick > MSP_TIMEOUT {
+		f.log("*** MSP INIT timeout ***")
+		f.mtick = f.ttick
+		f.state = MSP_INIT_INIT
+	}
+
+	for _, cmd := range f.link.Poll() {
+		f.log("*** MSP cmd " + itoa(int(cmd)) + " failed ***")
+		if f.state == MSP_INIT_WIP {
+			f.state = MSP_INIT_INIT
+			f.link.Reset()
+		}
+	}
+
+	if f.state == MSP_INIT_DONE {
+		if f.ttick-f.mtick > NAV_TIMEOUT {
+			f.log("*** MSP NAV TIMEOUT ***")
+			f.disp.INAVReset()
+			f.state = MSP_INIT_INIT
+			f.mode = 0
+			f.altp.Disengage()
+			f.fnc.Reset()
+			f.link.Reset()
+			f.vrelok = false
+		} else if !f.link.Pending(msp.MSP_NAV_STATUS) {
+			f.link.MSPCommand(msp.MSP_NAV_STATUS, nil)
+		}
+	}
+}
+
+// Fix handles a fix from the user's GPS
+func (f *Follower) Fix(fix gps.Fix) {
+	if f.state == MSP_INIT_NONE {
+		return
+	}
+	f.gtick = f.ttick
+	if !fix.Date.IsZero() && !fix.Date.Equal(f.date) {
+		f.date = fix.Date
+		f.log("GPS date " + fix.Date.Format("2006-01-02"))
+	}
+	ts := fix.Stamp.Format(f.cfg.TimeFormat)
+	f.disp.ShowTime(ts)
+	f.disp.ShowGPS(uint16(fix.Sats), fix.Quality)
+	f.log(ts + " [" + itoa(f.state) + ":" + itoa(int(f.mode)) + "] Qual: " + itoa(int(fix.Quality)) +
+		" sats: " + itoa(int(fix.Sats)) + " lat: " + FormatF32(fix.Lat, 6) + " lon: " + FormatF32(fix.Lon, 6))
+
+	ufix, accepted := fix, true
+	if f.cfg.KfEnable {
+		ufix, _, accepted = f.kf.Update(fix)
+		if !accepted {
+			f.log("GPS fix rejected by filter")
+		}
+	}
+
+	if fix.Quality == 0 || fix.Sats < f.cfg.MinSat {
+		f.state = MSP_INIT_INIT
+		f.mode = 0
+		f.altp.Disengage()
+		f.fnc.Reset()
+		f.disp.ClearINAVPos()
+		f.disp.ClearINAVSats()
+		return
+	}
+
+	switch f.state {
+	case MSP_INIT_INIT:
+		f.log("Starting MSP")
+		f.state = MSP_INIT_WIP
+		f.link.MSPCommand(msp.MSP_FC_VARIANT, nil)
+	case MSP_INIT_DONE:
+		if f.mode == msp.NAV_MODE_HOLD && accepted && !(ufix.Lat == 0.0 && ufix.Lon == 0.0) {
+			f.follow(ufix)
+		}
+	}
+}
+
+func (f *Follower) follow(ufix gps.Fix) {
+	c, d := geo.Csedist(f.vlat, f.vlon, ufix.Lat, ufix.Lon)
+	f.log("Follow (v->u) " + FormatF32(f.vlat, 6) + " " + FormatF32(f.vlon, 6) + " " +
+		FormatF32(ufix.Lat, 6) + " " + FormatF32(ufix.Lon, 6) + " dist: " + itoa(int(d)) + "m Brg: " + itoa(int(c)) + "°")
+
+	_, srtt := f.link.RTT()
+	dt := float32(0)
+	if f.pfix.Valid&gps.VALID_TIME != 0 {
+		dt = float32(ufix.Sub(f.pfix).Seconds())
+	}
+	f.pfix = ufix
+	plat, plon, spd, cog := f.predict.Project(ufix.Lat, ufix.Lon, ufix.Spd*gps.KNOTS_TO_MS, ufix.Hdg,
+		ufix.Valid&gps.VALID_VEL != 0, dt, float32(srtt.Seconds())/2)
+	tlat, tlon := f.standoff.Position(plat, plon, spd, cog)
+	tlat, tlon, fres := f.fnc.Check(tlat, tlon)
+	if fres != fence.FENCE_OK {
+		f.log("Fence: " + fence.Reason(fres))
+	}
+	tc, td := geo.Csedist(f.vlat, f.vlon, tlat, tlon)
+	if fres >= fence.FENCE_NO_HOME {
+		f.disp.ShowFenceHold(fence.Reason(fres))
+		return
+	}
+	if td <= f.cfg.MinFollowDist {
+		return
+	}
+	// face the user from the follow position
+	hdg := c
+	if tlat != ufix.Lat || tlon != ufix.Lon {
+		hdg, _ = geo.Csedist(tlat, tlon, ufix.Lat, ufix.Lon)
+	}
+	// without the user's altitude (e.g. RMC only), keep the vehicle altitude
+	alt := int32(0)
+	if ufix.Valid&gps.VALID_ALT != 0 {
+		if !f.altp.Engaged() {
+			f.altp.Engage(ufix.Alt, f.valt)
+		}
+		if f.vrelok {
+			alt = f.altp.Target(ufix.Alt, f.valt, f.vrel)
+		}
+	}
+	f.link.Update_WP(FOLLOW_WP, tlat, tlon, alt, uint16(hdg))
+	f.fnc.Commit(tlat, tlon)
+	f.log("Vehicle (c,d): " + FormatF32(tc, 0) + " " + FormatF32(td, 1) + " target: " +
+		FormatF32(tlat, 6) + " " + FormatF32(tlon, 6) + " alt: " + itoa(int(alt)) + "cm")
+	f.disp.ShowINAVPos(uint(d), uint16(c))
+	flag := byte(' ')
+	if fres != fence.FENCE_OK {
+		flag = fence.Reason(fres)[0]
+	}
+	f.disp.ShowFenceFlag(flag)
+	if f.cfg.ResetHome {
+		f.link.Update_WP(HOME_WP, ufix.Lat, ufix.Lon, 0, uint16(c))
+	}
+}
+
+// Message handles a message from the FC
+func (f *Follower) Message(v msp.MSPMsg) {
+	if _, err := f.link.Reply(v); err != nil {
+		f.mspError(v.Cmd, err)
+		return
+	}
+	f.mtick = f.ttick
+	switch v.Cmd {
+	case msp.MSP_FC_VARIANT:
+		vers, err := msp.DecodeFCVariant(v.Data)
+		if err != nil {
+			f.mspError(v.Cmd, err)
+			break
+		}
+		f.log("Firmware: " + vers + " MSPv" + itoa(int(f.link.Version())))
+		if vers == "INAV" {
+			f.link.MSPCommand(msp.MSP_FC_VERSION, nil)
+		}
+
+	case msp.MSP_FC_VERSION:
+		fcvers, err := msp.DecodeFCVersion(v.Data)
+		if err != nil {
+			f.mspError(v.Cmd, err)
+			break
+		}
+		f.log("Version: " + fcvers.String())
+		f.disp.ShowINAVVers(fcvers.String())
+		f.link.MSPCommand(msp.MSP_NAME, nil)
+
+	case msp.MSP_NAME:
+		name, _ := msp.DecodeName(v.Data)
+		if len(name) > 0 {
+			f.log("Name: " + name)
+		}
+		f.link.MSPCommand(msp.MSP2_INAV_MIXER, nil)
+
+	case msp.MSP2_INAV_MIXER:
+		mixer, err := msp.DecodeInavMixer(v.Data)
+		if err != nil {
+			f.mspError(v.Cmd, err)
+			break
+		}
+		f.log("Platform type: " + itoa(int(mixer.PlatformType)))
+		if mixer.PlatformType != f.cfg.DontFollowType {
+			f.state = MSP_INIT_DONE
+			f.mloop = 0
+		} else {
+			f.state = MSP_INIT_FAIL
+		}
+
+	case msp.MSP_NAV_STATUS:
+		ns, err := msp.DecodeNavStatus(v.Data)
+		if err != nil {
+			f.mspError(v.Cmd, err)
+			break
+		}
+		if f.mode != ns.Mode {
+			f.mode = ns.Mode
+			f.altp.Disengage()
+			f.log("nav status: " + itoa(int(f.mode)) + " state: " + itoa(int(ns.State)) + " error: " + itoa(int(ns.Error)))
+			if ns.Mode == 0 {
+				f.disp.ClearINAVPos()
+			}
+			f.disp.ShowMode(int16(f.state), int16(f.mode))
+		}
+		// until the home is known (the request may fail, or home not be set),
+		// every HOME_RETRY ticks
+		if f.mode == msp.NAV_MODE_HOLD && f.cfg.FenceRange > 0 && !f.fnc.HomeSet() &&
+			!f.link.Pending(msp.MSP_WP) && f.ttick >= f.htick {
+			f.link.MSPCommand(msp.MSP_WP, []byte{HOME_WP})
+			f.htick = f.ttick + HOME_RETRY
+		}
+		f.link.MSPCommand(msp.MSP_RAW_GPS, nil)
+
+	case msp.MSP_RAW_GPS:
+		rg, err := msp.DecodeRawGPS(v.Data)
+		if err != nil {
+			f.mspError(v.Cmd, err)
+			break
+		}
+		f.vlat = float32(rg.Lat) / 1e7
+		f.vlon = float32(rg.Lon) / 1e7
+		f.valt = float32(rg.Alt)
+		if !(rg.Lat == 0 && rg.Lon == 0) {
+			f.fnc.SetVehicle(f.vlat, f.vlon)
+		}
+
+		if f.mloop%10 == 0 {
+			f.disp.ShowINAVSats(uint16(rg.NumSat), rg.Hdop)
+			if f.mloop%100 == 0 {
+				_, srtt := f.link.RTT()
+				f.log("MSP: fix: " + itoa(int(rg.FixType)) + " sats: " + itoa(int(rg.NumSat)) +
+					" lat: " + FormatF32(f.vlat, 6) + " lon: " + FormatF32(f.vlon, 6) + " alt: " + itoa(int(rg.Alt)) +
+					" spd " + itoa(int(rg.Speed/100)) + " cog: " + itoa(int(rg.Cog/10)) + " hdop: " + itoa(int(rg.Hdop)) +
+					" rtt: " + itoa(int(srtt/time.Millisecond)) + "ms")
+			}
+		}
+		if f.state == MSP_INIT_DONE {
+			f.mloop += 1
+			if f.altp.Mode != ALT_HOLD {
+				f.link.MSPCommand(msp.MSP_ALTITUDE, nil)
+			}
+		}
+
+	case msp.MSP_ALTITUDE:
+		a, err := msp.DecodeAltitude(v.Data)
+		if err != nil {
+			f.mspError(v.Cmd, err)
+			break
+		}
+		f.vrel = float32(a.Alt) / 100
+		f.vrelok = true
+
+	case msp.MSP_SET_WP:
+		f.log("Got SET_WP ack")
+		f.link.VerifyWP()
+
+	case msp.MSP_WP:
+		if !f.fnc.HomeSet() {
+			if wp, err := msp.DecodeWaypoint(v.Data); err == nil && wp.Number == HOME_WP && !(wp.Lat == 0 && wp.Lon == 0) {
+				f.fnc.SetHome(float32(wp.Lat)/1e7, float32(wp.Lon)/1e7)
+				f.log("Fence home: " + FormatF32(float32(wp.Lat)/1e7, 6) + " " + FormatF32(float32(wp.Lon)/1e7, 6))
+			}
+		}
+		res, wp, err := f.link.CheckWP(v.Data)
+		if err != nil {
+			f.mspError(v.Cmd, err)
+			break
+		}
+		failures := itoa(int(f.link.Failures))
+		switch res {
+		case msp.WP_VERIFY_OK:
+			f.log("WP" + itoa(int(wp.Number)) + " verified")
+		case msp.WP_VERIFY_RETRY:
+			f.log("WP" + itoa(int(wp.Number)) + " mismatch, resending (failures: " + failures + ")")
+		case msp.WP_VERIFY_FAILED:
+			f.log("*** WP" + itoa(int(wp.Number)) + " verify failed (failures: " + failures + ") ***")
+		}
+
+	default:
+		f.log("** msp cmd: " + itoa(int(v.Cmd)) + " ***")
+	}
+}
+
+func (f *Follower) mspError(cmd uint16, err error) {
+	f.log("** msp cmd: " + itoa(int(cmd)) + " " + err.Error() + " ***")
+}
+
+func (f *Follower) log(s string) {
+	if f.logger != nil {
+		f.logger.Log(s)
+	}
+}
+
+func itoa(i int) string {
+	return strconv.Itoa(i)
+}
+
+func FormatF32(v float32, np int) string {
+	return strconv.FormatFloat(float64(v), 'f', np, 32)
+}
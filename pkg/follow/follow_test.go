@@ -0,0 +1,451 @@
+package follow
+
+import (
+	"geo"
+	"gps"
+	"msp"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.t
+}
+
+// frames captures the MSP requests written by the follower
+type frames struct {
+	d    *msp.Decoder
+	msgs []msp.MSPMsg
+}
+
+func (w *frames) Write(b []byte) (int, error) {
+	for _, c := range b {
+		if m, ok := w.d.Parse(c); ok {
+			w.msgs = append(w.msgs, m)
+		}
+	}
+	return len(b), nil
+}
+
+type stubDisplay struct {
+	init  int
+	reset int
+	hold  string
+}
+
+func (d *stubDisplay) InitScreen(vb bool)                    { d.init++ }
+func (d *stubDisplay) ShowMode(amode int16, imode int16)     {}
+func (d *stubDisplay) ClearTime(fail bool)                   {}
+func (d *stubDisplay) ShowTime(t string)                     {}
+func (d *stubDisplay) ShowGPS(nsat uint16, fix uint8)        {}
+func (d *stubDisplay) ShowINAVVers(t string)                 {}
+func (d *stubDisplay) ShowINAVSats(nsat uint16, hdop uint16) {}
+func (d *stubDisplay) ShowINAVPos(dist uint, brg uint16)     {}
+func (d *stubDisplay) ShowFenceHold(t string)                { d.hold = t }
+func (d *stubDisplay) ShowFenceFlag(c byte)                  {}
+func (d *stubDisplay) ClearINAVPos()                         {}
+func (d *stubDisplay) ClearINAVSats()                        {}
+func (d *stubDisplay) INAVReset()                            { d.reset++ }
+
+type rig struct {
+	t   *testing.T
+	f   *Follower
+	clk *fakeClock
+	w   *frames
+	d   *stubDisplay
+}
+
+const ulat, ulon = 51.5, -0.1
+
+func testConfig() Config {
+	return Config{
+		MinSat:         6,
+		DontFollowType: msp.PLATFORM_AIRPLANE,
+		MinFollowDist:  2.0,
+		TimeFormat:     "15:04:05",
+		MspVersion:     msp.MSP_V2,
+		MspRetries:     2,
+		MspTimeout:     500 * time.Millisecond,
+		KfAccel:        1.0,
+	}
+}
+
+func newRig(t *testing.T, cfg Config) *rig {
+	r := &rig{t: t, clk: &fakeClock{t: time.Unix(1000, 0)},
+		w: &frames{d: msp.NewDecoder()}, d: &stubDisplay{}}
+	r.f = NewFollower(cfg, r.w, r.clk, r.d, nil)
+	return r
+}
+
+func (r *rig) tick(n int) {
+	for j := 0; j < n; j++ {
+		r.clk.t = r.clk.t.Add(TICK)
+		r.f.Tick()
+	}
+}
+
+func userFix() gps.Fix {
+	return gps.Fix{Quality: 1, Sats: 10, Hdop: 0.9, Lat: ulat, Lon: ulon, Alt: 300,
+		Valid: gps.VALID_TIME | gps.VALID_POS | gps.VALID_ALT | gps.VALID_SATS}
+}
+
+// reply answers the follower as the FC
+func (r *rig) reply(cmd uint16, data []byte) {
+	r.f.Message(msp.MSPMsg{Cmd: cmd, Ok: true, Dir: msp.DIR_RESPONSE, Vers: msp.MSP_V2,
+		Len: uint16(len(data)), Data: data})
+}
+
+// sent returns (and forgets) the requests for cmd written since the last call
+func (r *rig) sent(cmd uint16) []msp.MSPMsg {
+	var ms, rest []msp.MSPMsg
+	for _, m := range r.w.msgs {
+		if m.Cmd == cmd {
+			ms = append(ms, m)
+		} else {
+			rest = append(rest, m)
+		}
+	}
+	r.w.msgs = rest
+	return ms
+}
+
+func (r *rig) expect(cmd uint16) msp.MSPMsg {
+	r.t.Helper()
+	ms := r.sent(cmd)
+	if len(ms) == 0 {
+		r.t.Fatalf("no request for MSP cmd %d", cmd)
+	}
+	return ms[len(ms)-1]
+}
+
+func (r *rig) state(want int) {
+	r.t.Helper()
+	if got := r.f.State(); got != want {
+		r.t.Fatalf("state %d, want %d", got, want)
+	}
+}
+
+// connect runs the follower from start up to MSP_INIT_DONE
+func (r *rig) connect(platform uint8) {
+	r.t.Helper()
+	r.tick(SPLASH_TIMEOUT - 1)
+	r.state(MSP_INIT_NONE)
+	r.f.Fix(userFix())
+	r.state(MSP_INIT_NONE)
+	r.tick(1)
+	r.state(MSP_INIT_INIT)
+
+	r.f.Fix(userFix())
+	r.state(MSP_INIT_WIP)
+	r.expect(msp.MSP_FC_VARIANT)
+	r.reply(msp.MSP_FC_VARIANT, []byte("INAV"))
+	r.expect(msp.MSP_FC_VERSION)
+	r.reply(msp.MSP_FC_VERSION, msp.FCVersion{Major: 7, Minor: 1}.Encode())
+	r.expect(msp.MSP_NAME)
+	r.reply(msp.MSP_NAME, []byte("test"))
+	r.expect(msp.MSP2_INAV_MIXER)
+	r.reply(msp.MSP2_INAV_MIXER, msp.InavMixer{PlatformType: platform}.Encode())
+}
+
+// hold puts the vehicle in POSHOLD 50m south of the user
+func (r *rig) hold() {
+	r.t.Helper()
+	r.tick(1)
+	r.expect(msp.MSP_NAV_STATUS)
+	r.reply(msp.MSP_NAV_STATUS, msp.NavStatus{Mode: msp.NAV_MODE_HOLD}.Encode())
+	r.expect(msp.MSP_RAW_GPS)
+	vlat := ulat - 50.0/111195.0
+	rg := msp.RawGPS{FixType: 2, NumSat: 12, Lat: int32(vlat * 1e7), Lon: int32(ulon * 1e7), Alt: 310}
+	r.reply(msp.MSP_RAW_GPS, rg.Encode())
+	if r.f.cfg.AltMode != ALT_HOLD {
+		r.expect(msp.MSP_ALTITUDE)
+		r.reply(msp.MSP_ALTITUDE, msp.Altitude{Alt: 1000}.Encode())
+	}
+}
+
+func TestConnect(t *testing.T) {
+	r := newRig(t, testConfig())
+	r.connect(msp.PLATFORM_MULTIROTOR)
+	r.state(MSP_INIT_DONE)
+	if r.d.init != 1 {
+		t.Errorf("InitScreen called %d times", r.d.init)
+	}
+	r.tick(1)
+	r.expect(msp.MSP_NAV_STATUS)
+	// one outstanding NAV_STATUS at a time
+	r.tick(1)
+	if n := len(r.sent(msp.MSP_NAV_STATUS)); n != 0 {
+		t.Errorf("%d NAV_STATUS requests while one is pending", n)
+	}
+}
+
+// a zero MspTimeout keeps the default rather than failing each request
+func TestZeroTimeout(t *testing.T) {
+	cfg := testConfig()
+	cfg.MspRetries = 0
+	cfg.MspTimeout = 0
+	r := newRig(t, cfg)
+	r.tick(SPLASH_TIMEOUT)
+	r.f.Fix(userFix())
+	r.expect(msp.MSP_FC_VARIANT)
+	r.tick(1)
+	r.state(MSP_INIT_WIP)
+	r.f.SetConfig(cfg)
+	r.tick(int(msp.DEFAULT_TIMEOUT/TICK) - 2)
+	r.state(MSP_INIT_WIP)
+	r.tick(1)
+	r.state(MSP_INIT_INIT)
+}
+
+func TestFollow(t *testing.T) {
+	r := newRig(t, testConfig())
+	r.connect(msp.PLATFORM_MULTIROTOR)
+	r.f.Fix(userFix())
+	if n := len(r.sent(msp.MSP_SET_WP)); n != 0 {
+		t.Fatalf("%d waypoints before POSHOLD", n)
+	}
+	r.hold()
+	r.f.Fix(userFix())
+	m := r.expect(msp.MSP_SET_WP)
+	wp, err := msp.DecodeWaypoint(m.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wp.Number != FOLLOW_WP || wp.Lat != int32(float32(ulat)*1e7) || wp.Lon != int32(float32(ulon)*1e7) || wp.Alt != 0 {
+		t.Errorf("waypoint %+v", wp)
+	}
+}
+
+func TestInitTimeout(t *testing.T) {
+	r := newRig(t, testConfig())
+	r.tick(SPLASH_TIMEOUT)
+	r.f.Fix(userFix())
+	r.expect(msp.MSP_FC_VARIANT)
+	// not INAV, so identification stalls
+	r.reply(msp.MSP_FC_VARIANT, []byte("BTFL"))
+	r.tick(MSP_TIMEOUT)
+	r.state(MSP_INIT_WIP)
+	r.tick(1)
+	r.state(MSP_INIT_INIT)
+	r.f.Fix(userFix())
+	r.state(MSP_INIT_WIP)
+	r.expect(msp.MSP_FC_VARIANT)
+}
+
+func TestNavTimeout(t *testing.T) {
+	r := newRig(t, testConfig())
+	r.connect(msp.PLATFORM_MULTIROTOR)
+	r.hold()
+	if r.f.Mode() != msp.NAV_MODE_HOLD {
+		t.Fatalf("mode %d, want POSHOLD", r.f.Mode())
+	}
+	// the FC stops replying
+	r.tick(NAV_TIMEOUT)
+	r.state(MSP_INIT_DONE)
+	r.tick(1)
+	r.state(MSP_INIT_INIT)
+	if r.d.reset != 1 || r.f.Mode() != 0 {
+		t.Errorf("INAVReset %d, mode %d", r.d.reset, r.f.Mode())
+	}
+}
+
+func TestDontFollow(t *testing.T) {
+	r := newRig(t, testConfig())
+	r.connect(msp.PLATFORM_AIRPLANE)
+	r.state(MSP_INIT_FAIL)
+	r.tick(20)
+	r.f.Fix(userFix())
+	r.state(MSP_INIT_FAIL)
+	if n := len(r.sent(msp.MSP_NAV_STATUS)); n != 0 {
+		t.Errorf("%d NAV_STATUS requests to a vehicle not followed", n)
+	}
+}
+
+func TestTrackerFailure(t *testing.T) {
+	cfg := testConfig()
+	r := newRig(t, cfg)
+	r.tick(SPLASH_TIMEOUT)
+	r.f.Fix(userFix())
+	r.expect(msp.MSP_FC_VARIANT)
+	r.reply(msp.MSP_FC_VARIANT, []byte("INAV"))
+	r.expect(msp.MSP_FC_VERSION)
+	// MSP_FC_VERSION is never answered; sent, then retried MspRetries times
+	per := int(cfg.MspTimeout / TICK)
+	r.tick(per * cfg.MspRetries)
+	if n := len(r.sent(msp.MSP_FC_VERSION)); n != cfg.MspRetries {
+		t.Errorf("%d retries, want %d", n, cfg.MspRetries)
+	}
+	r.state(MSP_INIT_WIP)
+	r.tick(per)
+	r.state(MSP_INIT_INIT)
+	if r.f.Link().Pending(msp.MSP_FC_VERSION) {
+		t.Error("failed request still pending")
+	}
+	r.f.Fix(userFix())
+	r.state(MSP_INIT_WIP)
+	r.expect(msp.MSP_FC_VARIANT)
+}
+
+// the velocity derived from successive fixes survives midnight and the
+// date arriving
+func TestLeadMidnight(t *testing.T) {
+	tod := func(h, m, s int) gps.Fix {
+		f := userFix()
+		f.Stamp = time.Date(0, 0, 0, h, m, s, 0, time.UTC)
+		return f
+	}
+	dated := func(d, h, m, s int) gps.Fix {
+		f := userFix()
+		f.Stamp = time.Date(2026, 10, d, h, m, s, 0, time.UTC)
+		f.Valid |= gps.VALID_DATE
+		return f
+	}
+	tests := []struct {
+		name   string
+		f1, f2 gps.Fix
+	}{
+		{"dated", dated(17, 23, 59, 59), dated(18, 0, 0, 0)},
+		{"time of day", tod(23, 59, 59), tod(0, 0, 0)},
+		{"date arrives", tod(12, 0, 0), dated(17, 12, 0, 1)},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.LeadHorizon = 1
+			cfg.LeadMax = 10
+			r := newRig(t, cfg)
+			r.connect(msp.PLATFORM_MULTIROTOR)
+			r.hold()
+			r.f.Fix(tc.f1)
+			r.expect(msp.MSP_SET_WP)
+			// 2m north in a second
+			tc.f2.Lat, tc.f2.Lon = geo.Destination(tc.f1.Lat, tc.f1.Lon, 0, 2)
+			r.f.Fix(tc.f2)
+			wp, err := msp.DecodeWaypoint(r.expect(msp.MSP_SET_WP).Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, d := geo.Csedist(tc.f2.Lat, tc.f2.Lon, float32(wp.Lat)/1e7, float32(wp.Lon)/1e7)
+			if d < 1.5 || d > 2.5 || (c > 5 && c < 355) {
+				t.Errorf("lead %.1fm at %.0f°, want 2m north", d, c)
+			}
+		})
+	}
+}
+
+func TestFollowAltitude(t *testing.T) {
+	noalt := userFix()
+	noalt.Alt = 0
+	noalt.Valid &^= gps.VALID_ALT
+	// the user at 300m AMSL; the vehicle at 310m AMSL, 10m above home
+	tests := []struct {
+		name  string
+		mode  int32
+		fixes []gps.Fix
+		want  []int32
+	}{
+		{"agl", ALT_AGL, []gps.Fix{userFix()}, []int32{2000}},
+		{"agl no altitude", ALT_AGL, []gps.Fix{noalt}, []int32{0}},
+		{"relative", ALT_RELATIVE, []gps.Fix{userFix(), userFix()}, []int32{1000, 1000}},
+		{"relative engaged with altitude", ALT_RELATIVE, []gps.Fix{noalt, userFix(), noalt}, []int32{0, 1000, 0}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.AltMode = tc.mode
+			cfg.AltOffset = 20
+			r := newRig(t, cfg)
+			r.connect(msp.PLATFORM_MULTIROTOR)
+			r.hold()
+			for j, fix := range tc.fixes {
+				r.f.Fix(fix)
+				wp, err := msp.DecodeWaypoint(r.expect(msp.MSP_SET_WP).Data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if wp.Alt != tc.want[j] {
+					t.Errorf("fix %d: altitude %dcm, want %dcm", j, wp.Alt, tc.want[j])
+				}
+			}
+		})
+	}
+}
+
+func TestFenceHome(t *testing.T) {
+	cfg := testConfig()
+	cfg.FenceRange = 100
+	r := newRig(t, cfg)
+	r.connect(msp.PLATFORM_MULTIROTOR)
+	r.hold()
+	nav := func() {
+		r.tick(1)
+		r.expect(msp.MSP_NAV_STATUS)
+		r.reply(msp.MSP_NAV_STATUS, msp.NavStatus{Mode: msp.NAV_MODE_HOLD}.Encode())
+	}
+	follows := func() bool {
+		r.f.Fix(userFix())
+		return len(r.sent(msp.MSP_SET_WP)) > 0
+	}
+
+	// the home request is NAKed
+	r.expect(msp.MSP_WP)
+	r.f.Message(msp.MSPMsg{Cmd: msp.MSP_WP, Dir: msp.DIR_ERROR, Vers: msp.MSP_V2, Ok: true})
+	if follows() || r.d.hold != "NoHome" {
+		t.Fatalf("followed without a home (%q)", r.d.hold)
+	}
+
+	// re-requested after HOME_RETRY ticks; home not set
+	for j := 1; j < HOME_RETRY; j++ {
+		nav()
+		if n := len(r.sent(msp.MSP_WP)); n != 0 {
+			t.Fatalf("MSP_WP re-requested after %d ticks", j)
+		}
+	}
+	nav()
+	r.expect(msp.MSP_WP)
+	r.reply(msp.MSP_WP, msp.Waypoint{Number: HOME_WP}.Encode())
+	if follows() {
+		t.Fatal("followed with an unset home")
+	}
+
+	// home set
+	for j := 0; j < HOME_RETRY; j++ {
+		nav()
+	}
+	r.expect(msp.MSP_WP)
+	home := msp.Waypoint{Number: HOME_WP, Lat: int32(ulat * 1e7), Lon: int32(ulon * 1e7)}
+	r.reply(msp.MSP_WP, home.Encode())
+	if !follows() {
+		t.Fatal("not following with home set")
+	}
+	for j := 0; j < HOME_RETRY; j++ {
+		nav()
+	}
+	if n := len(r.sent(msp.MSP_WP)); n != 0 {
+		t.Errorf("%d MSP_WP requests with home set", n)
+	}
+}
+
+// the step is limited from the vehicle, then from the positions sent
+func TestFenceStep(t *testing.T) {
+	cfg := testConfig()
+	cfg.FenceStep = 10
+	r := newRig(t, cfg)
+	r.connect(msp.PLATFORM_MULTIROTOR)
+	r.hold()
+	vlat := float32(ulat - 50.0/111195.0)
+	for j, want := range []float32{10, 20, 30} {
+		r.f.Fix(userFix())
+		wp, err := msp.DecodeWaypoint(r.expect(msp.MSP_SET_WP).Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, d := geo.Csedist(vlat, ulon, float32(wp.Lat)/1e7, float32(wp.Lon)/1e7); d < want-0.5 || d > want+0.5 {
+			t.Errorf("fix %d: %.1fm from the vehicle, want %.0fm", j, d, want)
+		}
+	}
+}
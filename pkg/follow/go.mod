@@ -0,0 +1,21 @@
+module follow
+
+require (
+	fence v1.0.0
+	filter v1.0.0
+	geo v1.0.0
+	gps v1.0.0
+	msp v1.0.0
+)
+
+replace fence v1.0.0 => ../fence
+
+replace filter v1.0.0 => ../filter
+
+replace geo v1.0.0 => ../geo
+
+replace gps v1.0.0 => ../gps
+
+replace msp v1.0.0 => ../msp
+
+go 1.19
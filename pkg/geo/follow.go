@@ -0,0 +1,48 @@
+package geo
+
+import (
+	"math"
+)
+
+// Follow geometry; where the vehicle should be relative to the user
+const (
+	FOLLOW_DIRECT   = iota // onto the user
+	FOLLOW_FIXED           // standoff on a fixed bearing from the user (relative to north)
+	FOLLOW_RELATIVE        // standoff on a bearing relative to the user's course
+	FOLLOW_CHASE           // standoff behind the user's direction of travel
+)
+
+// Below this speed (m/s) the user's course is unreliable, so the last good
+// course is used
+const COURSE_MIN_SPEED = 0.5
+
+type Standoff struct {
+	Mode    int32
+	Dist    float32 // m
+	Bearing float32 // deg
+	cog     float32
+}
+
+// Position returns the follow position for a user at lat/lon, moving at spd
+// (m/s) on course cog (deg)
+func (s *Standoff) Position(lat, lon, spd, cog float32) (float32, float32) {
+	if spd >= COURSE_MIN_SPEED {
+		s.cog = cog
+	}
+	if s.Dist <= 0 {
+		return lat, lon
+	}
+	var brg float32
+	switch s.Mode {
+	case FOLLOW_FIXED:
+		brg = s.Bearing
+	case FOLLOW_RELATIVE:
+		brg = s.cog + s.Bearing
+	case FOLLOW_CHASE:
+		brg = s.cog + 180
+	default:
+		return lat, lon
+	}
+	brg = float32(math.Mod(float64(brg), 360))
+	return Destination(lat, lon, brg, s.Dist)
+}
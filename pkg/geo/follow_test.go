@@ -0,0 +1,67 @@
+package geo
+
+import (
+	"testing"
+)
+
+func TestStandoff(t *testing.T) {
+	const lat, lon = 51.5, -0.1
+	tests := []struct {
+		name    string
+		mode    int32
+		dist    float32
+		bearing float32
+		spd     float32
+		cog     float32
+		want    float32 // bearing of the follow position from the user
+		wdist   float32
+	}{
+		{"direct", FOLLOW_DIRECT, 20, 180, 2, 90, 0, 0},
+		{"no distance", FOLLOW_FIXED, 0, 180, 2, 90, 0, 0},
+		{"fixed", FOLLOW_FIXED, 20, 135, 2, 90, 135, 20},
+		{"fixed stationary", FOLLOW_FIXED, 20, 135, 0, 0, 135, 20},
+		{"relative", FOLLOW_RELATIVE, 30, 90, 2, 90, 180, 30},
+		{"relative wraps", FOLLOW_RELATIVE, 30, 90, 2, 300, 30, 30},
+		{"chase", FOLLOW_CHASE, 15, 0, 3, 45, 225, 15},
+		{"chase wraps", FOLLOW_CHASE, 15, 0, 3, 270, 90, 15},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := Standoff{Mode: tc.mode, Dist: tc.dist, Bearing: tc.bearing}
+			plat, plon := s.Position(lat, lon, tc.spd, tc.cog)
+			c, d := Csedist(lat, lon, plat, plon)
+			if !within(d, tc.wdist, 1) || (tc.wdist > 0 && !sameBearing(c, tc.want, 1)) {
+				t.Errorf("%.1fm on %.1f°, want %.0fm on %.0f°", d, c, tc.wdist, tc.want)
+			}
+		})
+	}
+}
+
+// below COURSE_MIN_SPEED the last good course is kept
+func TestStandoffSlow(t *testing.T) {
+	const lat, lon = 51.5, -0.1
+	tests := []struct {
+		name string
+		mode int32
+		want float32
+	}{
+		{"relative", FOLLOW_RELATIVE, 180},
+		{"chase", FOLLOW_CHASE, 270},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := Standoff{Mode: tc.mode, Dist: 20, Bearing: 90}
+			s.Position(lat, lon, 2, 90)
+			// a slow, noisy course
+			plat, plon := s.Position(lat, lon, COURSE_MIN_SPEED/2, 200)
+			if c, d := Csedist(lat, lon, plat, plon); !within(d, 20, 1) || !sameBearing(c, tc.want, 1) {
+				t.Errorf("%.1fm on %.1f°, want 20m on %.0f°", d, c, tc.want)
+			}
+			// at speed, the new course
+			plat, plon = s.Position(lat, lon, COURSE_MIN_SPEED, 0)
+			if c, _ := Csedist(lat, lon, plat, plon); !sameBearing(c, tc.want-90, 1) {
+				t.Errorf("at speed %.1f°, want %.0f°", c, tc.want-90)
+			}
+		})
+	}
+}
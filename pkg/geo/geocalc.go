@@ -40,3 +40,16 @@ func Csedist(_lat1, _lon1, _lat2, _lon2 float32) (float32, float32) {
 	}
 	return float32(cse), float32(d * 1852.0)
 }
+
+// Destination returns the position dist metres from lat/lon on bearing brg
+func Destination(_lat, _lon, brg, dist float32) (float32, float32) {
+	lat1 := to_radians(_lat)
+	lon1 := to_radians(_lon)
+	tc := to_radians(brg)
+	d := nm2r(float64(dist) / 1852.0)
+
+	lat := math.Asin(math.Sin(lat1)*math.Cos(d) + math.Cos(lat1)*math.Sin(d)*math.Cos(tc))
+	dlon := math.Atan2(math.Sin(tc)*math.Sin(d)*math.Cos(lat1), math.Cos(d)-math.Sin(lat1)*math.Sin(lat))
+	lon := math.Mod(lon1+dlon+math.Pi, 2*math.Pi) - math.Pi
+	return float32(to_degrees(lat)), float32(to_degrees(lon))
+}
@@ -0,0 +1,55 @@
+package geo
+
+import (
+	"testing"
+)
+
+// within returns true if a is within tol of b
+func within(a, b, tol float32) bool {
+	return a >= b-tol && a <= b+tol
+}
+
+// bearings a and b agree to tol degrees
+func sameBearing(a, b, tol float32) bool {
+	d := a - b
+	for d > 180 {
+		d -= 360
+	}
+	for d < -180 {
+		d += 360
+	}
+	return within(d, 0, tol)
+}
+
+func TestDestination(t *testing.T) {
+	// a nautical mile is a minute of latitude
+	if lat, lon := Destination(0, 0, 0, 1852); !within(lat, 1.0/60, 1e-6) || lon != 0 {
+		t.Errorf("1nm north: %.7f %.7f", lat, lon)
+	}
+
+	tests := []struct {
+		name     string
+		lat, lon float32
+		brg      float32
+		dist     float32
+	}{
+		{"north", 51.5, -0.1, 0, 100},
+		{"east", 51.5, -0.1, 90, 250},
+		{"south west", 51.5, -0.1, 225, 1000},
+		{"equator", 0, 0, 45, 500},
+		{"southern", -33.86, 151.2, 300, 750},
+		{"antimeridian", 10, 179.9995, 90, 200},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			lat, lon := Destination(tc.lat, tc.lon, tc.brg, tc.dist)
+			if lon < -180 || lon > 180 {
+				t.Fatalf("longitude %.6f", lon)
+			}
+			c, d := Csedist(tc.lat, tc.lon, lat, lon)
+			if !within(d, tc.dist, 1) || !sameBearing(c, tc.brg, 0.5) {
+				t.Errorf("round trip %.1fm on %.1f°, want %.0fm on %.0f°", d, c, tc.dist, tc.brg)
+			}
+		})
+	}
+}
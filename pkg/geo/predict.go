@@ -0,0 +1,36 @@
+package geo
+
+// Predictor projects the user's position forward to compensate for GPS
+// and link latency. Velocity is taken from the fix (RMC) when available,
+// otherwise derived from successive fixes; a reported speed of 0 is used
+// as such.
+type Predictor struct {
+	Horizon float32 // s, lead in addition to the link latency
+	MaxLead float32 // m, cap on the projection; 0 disables prediction
+	llat    float32
+	llon    float32
+	lok     bool
+}
+
+// Project returns the predicted position and the velocity (m/s, deg) used.
+// vel is whether spd and cog are available; dt is the time (s) since the
+// previous fix, 0 if unknown; latency is the one way link latency in
+// seconds.
+func (p *Predictor) Project(lat, lon, spd, cog float32, vel bool, dt, latency float32) (float32, float32, float32, float32) {
+	if !vel && p.lok && dt > 0 && dt < 5 {
+		c, d := Csedist(p.llat, p.llon, lat, lon)
+		spd = d / dt
+		cog = c
+	}
+	p.llat, p.llon, p.lok = lat, lon, true
+
+	if p.MaxLead <= 0 || spd < COURSE_MIN_SPEED {
+		return lat, lon, spd, cog
+	}
+	lead := spd * (latency + p.Horizon)
+	if lead > p.MaxLead {
+		lead = p.MaxLead
+	}
+	plat, plon := Destination(lat, lon, cog, lead)
+	return plat, plon, spd, cog
+}
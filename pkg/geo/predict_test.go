@@ -0,0 +1,40 @@
+package geo
+
+import (
+	"testing"
+)
+
+func TestPredictor(t *testing.T) {
+	const lat, lon = 51.5, -0.1
+	// 2m of position jitter in a second
+	jlat, jlon := Destination(lat, lon, 90, 2)
+
+	tests := []struct {
+		name    string
+		spd     float32
+		vel     bool
+		dt      float32
+		wantSpd float32
+	}{
+		{"stationary", 0, true, 1, 0},
+		{"reported", 3, true, 1, 3},
+		{"derived", 0, false, 1, 2},
+		{"derived 5Hz", 0, false, 0.2, 10},
+		{"time unknown", 0, false, 0, 0},
+		{"gap", 0, false, 5, 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p := Predictor{Horizon: 1, MaxLead: 10}
+			p.Project(lat, lon, tc.spd, 90, tc.vel, 0, 0)
+			plat, plon, spd, _ := p.Project(jlat, jlon, tc.spd, 90, tc.vel, tc.dt, 0)
+			if d := spd - tc.wantSpd; d < -0.1 || d > 0.1 {
+				t.Errorf("speed %.2f, want %.2f", spd, tc.wantSpd)
+			}
+			_, lead := Csedist(jlat, jlon, plat, plon)
+			if d := lead - tc.wantSpd; d < -0.1 || d > 0.1 {
+				t.Errorf("lead %.2fm, want %.2fm", lead, tc.wantSpd)
+			}
+		})
+	}
+}
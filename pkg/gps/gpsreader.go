@@ -1,29 +1,21 @@
+//go:build tinygo
+
 package gps
 
 import (
 	"machine"
-	"strconv"
-	"strings"
 	"time"
 )
 
-type Fix struct {
-	Quality uint8
-	Stamp   time.Time
-	Lat     float32
-	Lon     float32
-	Alt     float32
-	Sats    uint8
-	Spd     float32
-	Hdg     float32
-}
-
 type GPSReader struct {
 	uart  machine.UART
 	fchan chan Fix
-	Fix   Fix
-	idx   int
-	line  []byte
+	schan chan Status
+	cchan chan Receiver
+	bchan chan uint32
+	auto  bool
+	rx    Receiver
+	*Parser
 }
 
 var (
@@ -31,8 +23,8 @@ var (
 )
 
 func NewGPSUartReader(uart machine.UART, fchan chan Fix) *GPSReader {
-	line := make([]byte, 128)
-	return &GPSReader{uart: uart, fchan: fchan, Fix: Fix{}, line: line}
+	return &GPSReader{uart: uart, fchan: fchan, Parser: NewParser(),
+		cchan: make(chan Receiver, 1), bchan: make(chan uint32, 1)}
 }
 
 func (g *GPSReader) SetBaud(baud uint32) {
@@ -40,135 +32,81 @@ func (g *GPSReader) SetBaud(baud uint32) {
 	gspdelay = time.Duration((10 * 1000000 / (2 * baud))) * time.Microsecond
 }
 
-func parseLatLon(ll string, nsew string, width int) float32 {
-	v := float32(0.0)
-	if len(ll) > 4 {
-		dd, err := strconv.ParseFloat(ll[0:width], 32)
-		if err == nil {
-			mm, err := strconv.ParseFloat(ll[width:], 32)
-			if err == nil {
-				v = float32(dd + (mm / 60))
-				if nsew == "S" || nsew == "W" {
-					v *= -1
-				}
-			}
-		}
-	}
-	return v
+func (g *GPSReader) Write(b []byte) (int, error) {
+	return g.uart.Write(b)
 }
 
-func parseSats(str string) uint8 {
-	v, err := strconv.ParseInt(str, 10, 32)
-	if err == nil {
-		return uint8(v)
-	} else {
-		return 0
-	}
+// Setup arranges for UartReader to probe the baud rate (if auto) and then
+// configure the receiver (unless rx.Type is RX_NONE) before reading fixes;
+// progress is sent to schan
+func (g *GPSReader) Setup(auto bool, rx Receiver, schan chan Status) {
+	g.auto = auto
+	g.rx = rx
+	g.schan = schan
 }
 
-func parseF32(str string) float32 {
-	v, err := strconv.ParseFloat(str, 32)
-	if err == nil {
-		return float32(v)
-	} else {
-		return float32(0.0)
+// ChangeBaud sets the baud rate from the reader goroutine (after any
+// probe or configuration in progress); the latest rate is kept
+func (g *GPSReader) ChangeBaud(baud uint32) {
+	select {
+	case <-g.bchan:
+	default:
 	}
+	g.bchan <- baud
 }
 
-func parseTime(str string) time.Time {
-	if len(str) < 6 {
-		return time.Time{}
-	}
-	h, _ := strconv.ParseInt(str[0:2], 10, 8)
-	m, _ := strconv.ParseInt(str[2:4], 10, 8)
-	s, _ := strconv.ParseInt(str[4:6], 10, 8)
-	ms := int64(0)
-	if len(str) == 9 {
-		ms, _ = strconv.ParseInt(str[7:9], 10, 8)
+// Configure (re)configures the receiver from the reader goroutine
+func (g *GPSReader) Configure(rx Receiver) {
+	select {
+	case g.cchan <- rx:
+	default:
 	}
-	t := time.Date(0, 0, 0, int(h), int(m), int(s), int(ms), time.UTC)
-	return t
 }
 
-func valid_nmea(str string) bool {
-	if len(str) > 6 && str[0] == '$' && str[len(str)-3] == '*' {
-		chk := byte(0)
-		for i := 1; i < len(str)-3; i++ {
-			chk ^= str[i]
-		}
-		cs, _ := strconv.ParseInt(str[len(str)-2:len(str)], 16, 8)
-		return chk == byte(cs)
-	} else {
-		return false
+func (g *GPSReader) report(s Status) {
+	if g.schan != nil {
+		g.schan <- s
 	}
 }
 
-func (r *GPSReader) parse_nmea(nmea string) bool {
-	if valid_nmea(nmea) {
-		typ := nmea[3:6]
-		last := r.Fix.Stamp
-		switch typ {
-		case "GGA":
-			part := strings.Split(nmea, ",")
-			if len(part) != 15 {
-				return false
-			}
-			r.Fix.Stamp = parseTime(part[1])
-			r.Fix.Lat = parseLatLon(part[2], part[3], 2)
-			r.Fix.Lon = parseLatLon(part[4], part[5], 3)
-			r.Fix.Alt = parseF32(part[9])
-			r.Fix.Sats = parseSats(part[7])
-			r.Fix.Quality = uint8(part[6][0] - 48)
-			return r.Fix.Stamp != last
-		case "RMC":
-			part := strings.Split(nmea, ",")
-			if len(part) != 13 {
-				return false
-			}
-			r.Fix.Stamp = parseTime(part[1])
-			r.Fix.Lat = parseLatLon(part[3], part[4], 2)
-			r.Fix.Lon = parseLatLon(part[5], part[6], 3)
-			r.Fix.Spd = parseF32(part[7])
-			r.Fix.Hdg = parseF32(part[8])
-			return r.Fix.Stamp != last
-		default:
-		}
+// ReadFor parses input for d, delivering fixes
+func (g *GPSReader) ReadFor(d time.Duration) {
+	for end := time.Now().Add(d); time.Now().Before(end); {
+		g.poll()
 	}
-	return false
 }
 
-func (r *GPSReader) builder(c byte) {
-	if c == '$' {
-		r.idx = 0
-	}
-	if r.idx == 127 {
-		r.idx = 0
-	}
-	if c == 0xd {
-		return
-	}
-	if c == 0xa {
-		if r.parse_nmea(string(r.line[:r.idx])) {
-			r.fchan <- r.Fix
+func (g *GPSReader) poll() {
+	if g.uart.Buffered() > 0 {
+		c, err := g.uart.ReadByte()
+		if err == nil {
+			if g.Parse(c) {
+				g.fchan <- g.Fix
+			}
+		} else {
+			println(err)
 		}
-		r.idx = 0
 	} else {
-		r.line[r.idx] = c
-		r.idx += 1
+		time.Sleep(gspdelay)
 	}
 }
 
-func (r *GPSReader) UartReader() {
+func (g *GPSReader) UartReader() {
+	if g.auto {
+		Probe(g, g.Parser, g.rx.Baud, g.report)
+	}
+	if g.rx.Type != RX_NONE {
+		Configure(g, g.Parser, g.rx, g.report)
+	}
 	for {
-		if r.uart.Buffered() > 0 {
-			c, err := r.uart.ReadByte()
-			if err == nil {
-				r.builder(c)
-			} else {
-				println(err)
-			}
-		} else {
-			time.Sleep(gspdelay)
+		select {
+		case rx := <-g.cchan:
+			g.rx = rx
+			Configure(g, g.Parser, rx, g.report)
+		case baud := <-g.bchan:
+			g.SetBaud(baud)
+		default:
+			g.poll()
 		}
 	}
 }
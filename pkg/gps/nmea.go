@@ -0,0 +1,634 @@
+// Package gps decodes NMEA and UBX from the ground GPS receiver into Fix.
+// The UART reader (gpsreader.go) is TinyGo only; the parsers and receiver
+// set up (through Port) are shared with the host build and tools.
+package gps
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Constellations of the per-constellation satellite counts (Fix.Sys)
+const (
+	SYS_GPS = iota
+	SYS_GLONASS
+	SYS_GALILEO
+	SYS_BEIDOU
+	SYS_OTHER // SBAS, QZSS, NavIC
+	SYS_N
+)
+
+// SatCount summarises the satellites of a constellation
+type SatCount struct {
+	InView  uint8 // GSV
+	Tracked uint8 // GSV, with a SNR
+	Used    uint8 // GSA
+	Snr     uint8 // mean SNR (dBHz) of those tracked
+}
+
+// Fix is a navigation epoch. Until the date is known (VALID_DATE clear,
+// before the first RMC or ZDA or from a GGA only receiver), Stamp holds
+// only the time of day; use Sub to compare fixes.
+type Fix struct {
+	Quality uint8
+	FixType uint8 // GSA; 1 = none, 2 = 2D, 3 = 3D
+	Stamp   time.Time
+	Lat     float32
+	Lon     float32
+	Alt     float32
+	Sats    uint8
+	Spd     float32 // knots
+	Hdg     float32
+	Hdop    float32
+	Pdop    float32
+	Vdop    float32
+	Lat7    int32   // deg * 1e7, full precision
+	Lon7    int32   // deg * 1e7
+	VelN    float32 // m/s (UBX)
+	VelE    float32
+	VelD    float32
+	HAcc    float32 // m, accuracy estimates (UBX)
+	VAcc    float32
+	SAcc    float32   // m/s
+	Date    time.Time // RMC or ZDA, zero if unknown
+	Rx      time.Time // local receive time (monotonic)
+	Sys     [SYS_N]SatCount
+	Valid   uint16 // VALID_*, the fields set in this epoch
+}
+
+// Fix.Valid flags
+const (
+	VALID_TIME = 1 << iota
+	VALID_DATE
+	VALID_POS  // Lat, Lon (and Lat7, Lon7)
+	VALID_ALT  // Alt
+	VALID_SATS // Sats, Hdop
+	VALID_VEL  // Spd, Hdg
+	VALID_NED  // VelN, VelE, VelD (UBX)
+	VALID_DOP  // Pdop, Vdop (GSA or UBX)
+	VALID_ACC  // HAcc, VAcc (GST or UBX)
+)
+
+// Sub returns the time from fix p to f. If either lacks the date, the
+// times of day are compared, taking the shorter way round midnight.
+func (f Fix) Sub(p Fix) time.Duration {
+	if f.Valid&p.Valid&VALID_DATE != 0 {
+		return f.Stamp.Sub(p.Stamp)
+	}
+	const day = 24 * time.Hour
+	d := (timeOfDay(f.Stamp) - timeOfDay(p.Stamp)) % day
+	if d > day/2 {
+		d -= day
+	} else if d < -day/2 {
+		d += day
+	}
+	return d
+}
+
+func timeOfDay(t time.Time) time.Duration {
+	h, m, s := t.Clock()
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute +
+		time.Duration(s)*time.Second + time.Duration(t.Nanosecond())
+}
+
+// NMEAParser assembles NMEA sentences into fixes. An epoch is emitted on
+// its last sentence type, learnt from the previous epochs, or else when the
+// next epoch starts; so the first epoch is emitted as the second starts. A
+// sentence arriving after the epoch was emitted (e.g. a 1 Hz GST following
+// the GSA of a 5 Hz receiver) is dropped.
+type NMEAParser struct {
+	Fix       Fix
+	Sentences uint32 // valid sentences, of any type
+	idx       int
+	line      []byte
+	prev      string // previous sentence type
+	gsv       [SYS_N]gsvState
+	work      Fix // the epoch being assembled
+	started   bool
+	emitted   bool
+	tod       time.Time // time of day of the epoch
+	date      time.Time
+	dated     time.Time // the latest stamp with the date, for midnight
+	seen      int       // SEEN_* of the epoch
+	pseen     int       // SEEN_* of the previous epoch
+	order     [8]seenType
+	norder    int      // types of the epoch, in order
+	last      seenType // the type that completes an epoch
+	rmcok     bool
+	rmcq      uint8
+}
+
+// seenType is an epoch completing sentence type, as received
+type seenType struct {
+	typ  string
+	seen int
+}
+
+// sentence types seen in an epoch
+const (
+	SEEN_GGA = 1 << iota
+	SEEN_RMC
+	SEEN_GSA
+	SEEN_VTG
+	SEEN_GST
+)
+
+// gsvState accumulates a constellation's GSV sequence; for NMEA 4.1
+// receivers reporting several signals, only the first signal seen is used
+type gsvState struct {
+	sig     string
+	tracked uint8
+	snr     uint
+}
+
+func NewNMEAParser() *NMEAParser {
+	return &NMEAParser{line: make([]byte, 128)}
+}
+
+func parseLatLon(ll string, nsew string, width int) float64 {
+	v := 0.0
+	if len(ll) > 4 {
+		dd, err := strconv.ParseFloat(ll[0:width], 64)
+		if err == nil {
+			mm, err := strconv.ParseFloat(ll[width:], 64)
+			if err == nil {
+				v = dd + (mm / 60)
+				if nsew == "S" || nsew == "W" {
+					v *= -1
+				}
+			}
+		}
+	}
+	return v
+}
+
+func (r *NMEAParser) setLatLon(lat, lon float64) {
+	r.work.Lat = float32(lat)
+	r.work.Lon = float32(lon)
+	r.work.Lat7 = int32(math.Round(lat * 1e7))
+	r.work.Lon7 = int32(math.Round(lon * 1e7))
+}
+
+func parseSats(str string) uint8 {
+	v, err := strconv.ParseInt(str, 10, 32)
+	if err == nil {
+		return uint8(v)
+	} else {
+		return 0
+	}
+}
+
+func parseF32(str string) float32 {
+	v, err := strconv.ParseFloat(str, 32)
+	if err == nil {
+		return float32(v)
+	} else {
+		return float32(0.0)
+	}
+}
+
+// parseTime parses hhmmss[.sss], with any number of decimals; the date
+// is added by withDate, if known
+func parseTime(str string) time.Time {
+	if len(str) < 6 {
+		return time.Time{}
+	}
+	h := parseInt(str[0:2])
+	m := parseInt(str[2:4])
+	s := parseInt(str[4:6])
+	ns := 0
+	if len(str) > 7 && str[6] == '.' {
+		frac := str[7:]
+		if len(frac) > 9 {
+			frac = frac[:9]
+		}
+		ns = parseInt(frac)
+		for j := len(frac); j < 9; j++ {
+			ns *= 10
+		}
+	}
+	return time.Date(0, 0, 0, h, m, s, ns, time.UTC)
+}
+
+// parseDate parses the RMC ddmmyy date
+func parseDate(str string) time.Time {
+	if len(str) != 6 {
+		return time.Time{}
+	}
+	d := parseInt(str[0:2])
+	m := parseInt(str[2:4])
+	y := parseInt(str[4:6])
+	if d == 0 || m == 0 {
+		return time.Time{}
+	}
+	if y < 80 {
+		y += 2000
+	} else {
+		y += 1900
+	}
+	return time.Date(y, time.Month(m), d, 0, 0, 0, 0, time.UTC)
+}
+
+// talkerSystem maps a talker to a constellation; -1 for GN (mixed)
+func talkerSystem(t string) int {
+	switch t {
+	case "GP":
+		return SYS_GPS
+	case "GL":
+		return SYS_GLONASS
+	case "GA":
+		return SYS_GALILEO
+	case "GB", "BD":
+		return SYS_BEIDOU
+	case "GN":
+		return -1
+	}
+	return SYS_OTHER
+}
+
+// prnSystem maps a satellite number (NMEA and u-blox extended numbering)
+// to a constellation
+func prnSystem(prn int) int {
+	switch {
+	case prn >= 1 && prn <= 32:
+		return SYS_GPS
+	case prn >= 65 && prn <= 96:
+		return SYS_GLONASS
+	case prn >= 301 && prn <= 336:
+		return SYS_GALILEO
+	case (prn >= 201 && prn <= 237) || (prn >= 401 && prn <= 437):
+		return SYS_BEIDOU
+	}
+	return SYS_OTHER
+}
+
+// NMEA 4.1 system ids (GSA)
+func systemId(id string) int {
+	switch id {
+	case "1":
+		return SYS_GPS
+	case "2":
+		return SYS_GLONASS
+	case "3":
+		return SYS_GALILEO
+	case "4":
+		return SYS_BEIDOU
+	case "":
+		return -1
+	}
+	return SYS_OTHER
+}
+
+func parseInt(str string) int {
+	v, err := strconv.Atoi(str)
+	if err != nil {
+		return 0
+	}
+	return v
+}
+
+// withDate adds the last known date to a time of day; the date is
+// advanced at midnight, before the next RMC or ZDA
+func (r *NMEAParser) withDate(t time.Time) time.Time {
+	if r.date.IsZero() || t.IsZero() {
+		return t
+	}
+	y, m, d := r.date.Date()
+	st := time.Date(y, m, d, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	if !r.dated.IsZero() && st.Before(r.dated.Add(-12*time.Hour)) {
+		r.date = r.date.AddDate(0, 0, 1)
+		st = st.AddDate(0, 0, 1)
+	}
+	if st.After(r.dated) {
+		r.dated = st
+	}
+	return st
+}
+
+// setDate sets the date of the sentence's time (r.tod)
+func (r *NMEAParser) setDate(d time.Time) {
+	r.date = d
+	r.dated = time.Time{}
+	if !r.tod.IsZero() {
+		r.work.Stamp = r.withDate(r.tod)
+	}
+	r.work.Date = r.date
+	r.work.Valid |= VALID_DATE
+}
+
+// epoch starts a new epoch at time of day t, emitting the previous one if
+// it was not complete; the measurements are reset, the date and
+// satellites in view are kept
+func (r *NMEAParser) epoch(t time.Time) bool {
+	if r.started && t.Equal(r.tod) {
+		return false
+	}
+	done := false
+	if r.started && !r.emitted && r.seen != 0 {
+		r.emit()
+		done = true
+	}
+	// the last sentence type of an epoch is learnt from the previous ones,
+	// unless it was only dropped from this one
+	if r.started && r.norder > 0 {
+		if r.last.seen&r.pseen == 0 || r.last.seen&r.seen != 0 {
+			r.last = r.terminator()
+		}
+		r.pseen = r.seen
+	}
+	r.started = true
+	r.emitted = false
+	r.seen = 0
+	r.norder = 0
+	r.tod = t
+	r.rmcok = false
+	r.work = Fix{Sys: r.work.Sys}
+	r.work.Stamp = r.withDate(t)
+	r.work.Date = r.date
+	r.work.Valid = VALID_TIME
+	if r.work.Stamp.Year() > 0 {
+		r.work.Valid |= VALID_DATE
+	}
+	return done
+}
+
+// terminator returns the last type of the epoch that was also seen in the
+// epoch before, so a less frequent sentence (e.g. GST at 1 Hz with 5 Hz
+// fixes) does not become the terminator; the first epoch has only its own
+func (r *NMEAParser) terminator() seenType {
+	for j := r.norder - 1; j >= 0; j-- {
+		if r.order[j].seen&r.pseen != 0 {
+			return r.order[j]
+		}
+	}
+	return r.order[r.norder-1]
+}
+
+// emit publishes the epoch, filling in from what the receiver sent
+func (r *NMEAParser) emit() {
+	f := r.work
+	if r.seen&SEEN_GGA == 0 && r.rmcok {
+		// no GGA; RMC status and GSA satellites
+		f.Quality = r.rmcq
+		if f.Valid&VALID_SATS == 0 {
+			for _, c := range f.Sys {
+				f.Sats += c.Used
+			}
+			if f.Sats > 0 {
+				f.Valid |= VALID_SATS
+			}
+		}
+	}
+	f.checkValid()
+	f.Rx = time.Now()
+	r.Fix = f
+	r.emitted = true
+}
+
+// checkValid clears the position and velocity flags of a fix without a
+// solution
+func (f *Fix) checkValid() {
+	if f.Quality == 0 {
+		f.Valid &^= VALID_POS | VALID_ALT | VALID_VEL | VALID_NED
+	}
+}
+
+func valid_nmea(str string) bool {
+	if len(str) > 6 && str[0] == '$' && str[len(str)-3] == '*' {
+		chk := byte(0)
+		for i := 1; i < len(str)-3; i++ {
+			chk ^= str[i]
+		}
+		cs, _ := strconv.ParseInt(str[len(str)-2:len(str)], 16, 8)
+		return chk == byte(cs)
+	} else {
+		return false
+	}
+}
+
+func (r *NMEAParser) parse_nmea(nmea string) bool {
+	if !valid_nmea(nmea) {
+		return false
+	}
+	r.Sentences++
+	talker := nmea[1:3]
+	typ := nmea[3:6]
+	prev := r.prev
+	r.prev = typ
+	done := false
+	// a run of GSA (one per constellation) completes the epoch when it ends
+	if prev == "GSA" && typ != "GSA" && r.last.typ == "GSA" && r.started && !r.emitted {
+		r.emit()
+		done = true
+	}
+	if nmea[1] == 'P' {
+		// proprietary
+		return done
+	}
+	// fields, without the checksum
+	part := strings.Split(nmea[:len(nmea)-3], ",")
+	seen := 0
+	switch typ {
+	case "GGA":
+		if len(part) < 10 {
+			return done
+		}
+		seen = SEEN_GGA
+		done = r.epoch(parseTime(part[1])) || done
+		r.work.Quality = parseSats(part[6])
+		r.setLatLon(parseLatLon(part[2], part[3], 2), parseLatLon(part[4], part[5], 3))
+		r.work.Alt = parseF32(part[9])
+		r.work.Sats = parseSats(part[7])
+		r.work.Hdop = parseF32(part[8])
+		r.work.Valid |= VALID_POS | VALID_ALT | VALID_SATS
+	case "RMC":
+		if len(part) < 10 {
+			return done
+		}
+		seen = SEEN_RMC
+		done = r.epoch(parseTime(part[1])) || done
+		if d := parseDate(part[9]); !d.IsZero() {
+			r.setDate(d)
+		}
+		r.rmcok = part[2] == "A"
+		r.rmcq = 0
+		if r.rmcok {
+			r.rmcq = 1
+			if len(part) > 12 && part[12] == "D" {
+				r.rmcq = 2
+			}
+		}
+		if r.seen&SEEN_GGA == 0 && r.rmcok {
+			r.setLatLon(parseLatLon(part[3], part[4], 2), parseLatLon(part[5], part[6], 3))
+			r.work.Valid |= VALID_POS
+		}
+		if part[7] != "" {
+			r.work.Spd = parseF32(part[7])
+			r.work.Hdg = parseF32(part[8])
+			r.work.Valid |= VALID_VEL
+		}
+	case "GSA":
+		if len(part) < 18 {
+			return done
+		}
+		seen = SEEN_GSA
+		r.parse_gsa(talker, part, prev != "GSA")
+	case "GSV":
+		if len(part) < 4 {
+			return done
+		}
+		r.parse_gsv(talker, part)
+	case "VTG":
+		if len(part) < 8 {
+			return done
+		}
+		seen = SEEN_VTG
+		if part[1] != "" && part[5] != "" {
+			r.work.Hdg = parseF32(part[1])
+			r.work.Spd = parseF32(part[5])
+			r.work.Valid |= VALID_VEL
+		}
+	case "GST":
+		if len(part) < 9 {
+			return done
+		}
+		seen = SEEN_GST
+		done = r.epoch(parseTime(part[1])) || done
+		if part[6] != "" && part[7] != "" {
+			lat := parseF32(part[6])
+			lon := parseF32(part[7])
+			r.work.HAcc = float32(math.Sqrt(float64(lat*lat + lon*lon)))
+			r.work.VAcc = parseF32(part[8])
+			r.work.Valid |= VALID_ACC
+		}
+	case "ZDA":
+		if len(part) < 5 {
+			return done
+		}
+		done = r.epoch(parseTime(part[1])) || done
+		y := parseInt(part[4])
+		m := parseInt(part[3])
+		d := parseInt(part[2])
+		if y > 0 && m > 0 && d > 0 {
+			r.setDate(time.Date(y, time.Month(m), d, 0, 0, 0, 0, time.UTC))
+		}
+	default:
+	}
+	// GSV and ZDA (often less frequent) do not complete an epoch. A
+	// sentence that both starts and completes an epoch (e.g. GGA only)
+	// supersedes the previous epoch if that was still incomplete.
+	if seen != 0 && r.started {
+		r.seen |= seen
+		if n := r.norder; n < len(r.order) && (n == 0 || r.order[n-1].typ != typ) {
+			r.order[n] = seenType{typ, seen}
+			r.norder++
+		}
+		if typ == r.last.typ && typ != "GSA" && !r.emitted {
+			r.emit()
+			done = true
+		}
+	}
+	return done
+}
+
+// parse_gsa counts the satellites used; a multi-constellation receiver
+// sends a (consecutive) GSA per constellation each epoch
+func (r *NMEAParser) parse_gsa(talker string, part []string, first bool) {
+	if first {
+		for j := range r.work.Sys {
+			r.work.Sys[j].Used = 0
+		}
+	}
+	sys := talkerSystem(talker)
+	if len(part) > 18 {
+		if id := systemId(part[18]); id >= 0 {
+			sys = id
+		}
+	}
+	for _, p := range part[3:15] {
+		if p == "" {
+			continue
+		}
+		s := sys
+		if s < 0 {
+			s = prnSystem(parseInt(p))
+		}
+		r.work.Sys[s].Used++
+	}
+	r.work.FixType = parseSats(part[2])
+	r.work.Pdop = parseF32(part[15])
+	r.work.Vdop = parseF32(part[17])
+	if r.work.Valid&VALID_SATS == 0 {
+		r.work.Hdop = parseF32(part[16])
+	}
+	r.work.Valid |= VALID_DOP
+}
+
+// parse_gsv counts the satellites in view, and those tracked (with their
+// mean SNR), at the end of each constellation's sequence
+func (r *NMEAParser) parse_gsv(talker string, part []string) {
+	sys := talkerSystem(talker)
+	if sys < 0 {
+		// GN GSV is not standard; classify by the first satellite
+		if len(part) < 5 {
+			return
+		}
+		sys = prnSystem(parseInt(part[4]))
+	}
+	sig := ""
+	if (len(part)-4)%4 == 1 {
+		sig = part[len(part)-1]
+		part = part[:len(part)-1]
+	}
+	st := &r.gsv[sys]
+	num := parseInt(part[2])
+	if num == 1 {
+		if st.sig != "" && sig != st.sig {
+			return
+		}
+		st.sig = sig
+		st.tracked = 0
+		st.snr = 0
+	} else if sig != st.sig {
+		return
+	}
+	for j := 4; j+3 < len(part); j += 4 {
+		if snr := parseInt(part[j+3]); snr > 0 {
+			st.tracked++
+			st.snr += uint(snr)
+		}
+	}
+	if num == parseInt(part[1]) {
+		c := &r.work.Sys[sys]
+		c.InView = parseSats(part[3])
+		c.Tracked = st.tracked
+		c.Snr = 0
+		if st.tracked > 0 {
+			c.Snr = uint8(st.snr / uint(st.tracked))
+		}
+	}
+}
+
+// Parse consumes a byte of NMEA; it returns true when a sentence
+// completes a new fix, which is then available in r.Fix
+func (r *NMEAParser) Parse(c byte) bool {
+	if c == '$' {
+		r.idx = 0
+	}
+	if r.idx == 127 {
+		r.idx = 0
+	}
+	if c == 0xd {
+		return false
+	}
+	if c == 0xa {
+		ok := r.parse_nmea(string(r.line[:r.idx]))
+		r.idx = 0
+		return ok
+	}
+	r.line[r.idx] = c
+	r.idx += 1
+	return false
+}
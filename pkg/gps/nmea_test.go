@@ -0,0 +1,553 @@
+package gps
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// sentence adds the delimiters and checksum to body
+func sentence(body string) string {
+	return string(NMEACommand(body))
+}
+
+// parse feeds sentences (bodies, without '$' and checksum) to p,
+// returning the fixes completed
+func parse(p *NMEAParser, bodies ...string) []Fix {
+	var fixes []Fix
+	for _, b := range bodies {
+		s := sentence(b)
+		for j := 0; j < len(s); j++ {
+			if p.Parse(s[j]) {
+				fixes = append(fixes, p.Fix)
+			}
+		}
+	}
+	return fixes
+}
+
+func TestGSA(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    []string
+		used  [SYS_N]uint8
+		fix   uint8
+		pdop  float32
+		valid bool
+	}{
+		{"GP", []string{"GPGSA,A,3,04,05,09,12,24,,,,,,,,2.5,1.3,2.1"},
+			[SYS_N]uint8{SYS_GPS: 5}, 3, 2.5, true},
+		{"GN by PRN", []string{
+			"GNGSA,A,3,05,13,15,18,,,,,,,,,1.8,1.0,1.5",
+			"GNGSA,A,3,67,68,77,,,,,,,,,,1.8,1.0,1.5"},
+			[SYS_N]uint8{SYS_GPS: 4, SYS_GLONASS: 3}, 3, 1.8, true},
+		{"GN system id", []string{
+			"GNGSA,A,3,05,13,15,18,20,24,,,,,,,1.60,0.95,1.29,1",
+			"GNGSA,A,3,67,68,77,78,,,,,,,,,1.60,0.95,1.29,2",
+			"GNGSA,A,3,07,08,26,30,,,,,,,,,1.60,0.95,1.29,3",
+			"GNGSA,A,3,19,20,,,,,,,,,,,1.60,0.95,1.29,4"},
+			[SYS_N]uint8{SYS_GPS: 6, SYS_GLONASS: 4, SYS_GALILEO: 4, SYS_BEIDOU: 2}, 3, 1.6, true},
+		{"GL", []string{"GLGSA,A,3,65,66,74,,,,,,,,,,2.1,1.1,1.8"},
+			[SYS_N]uint8{SYS_GLONASS: 3}, 3, 2.1, true},
+		{"GA", []string{"GAGSA,A,3,02,11,25,,,,,,,,,,2.1,1.1,1.8"},
+			[SYS_N]uint8{SYS_GALILEO: 3}, 3, 2.1, true},
+		{"GB", []string{"GBGSA,A,2,06,09,,,,,,,,,,,3.2,2.0,2.5"},
+			[SYS_N]uint8{SYS_BEIDOU: 2}, 2, 3.2, true},
+		{"SBAS PRN", []string{"GNGSA,A,3,05,13,133,,,,,,,,,,1.8,1.0,1.5"},
+			[SYS_N]uint8{SYS_GPS: 2, SYS_OTHER: 1}, 3, 1.8, true},
+		{"empty", []string{"GPGSA,A,1,,,,,,,,,,,,,,,"},
+			[SYS_N]uint8{}, 1, 0, true},
+		{"short", []string{"GPGSA,A,3,04,05"},
+			[SYS_N]uint8{}, 0, 0, false},
+		{"new run", []string{
+			"GPGSA,A,3,04,05,09,12,24,,,,,,,,2.5,1.3,2.1",
+			"GPGSV,1,1,00",
+			"GPGSA,A,3,04,05,,,,,,,,,,,2.5,1.3,2.1"},
+			[SYS_N]uint8{SYS_GPS: 2}, 3, 2.5, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p := NewNMEAParser()
+			parse(p, tc.in...)
+			w := p.work
+			var used [SYS_N]uint8
+			for j := range used {
+				used[j] = w.Sys[j].Used
+			}
+			if used != tc.used {
+				t.Errorf("used %v, want %v", used, tc.used)
+			}
+			if w.FixType != tc.fix || w.Pdop != tc.pdop || (w.Valid&VALID_DOP != 0) != tc.valid {
+				t.Errorf("fix type %d pdop %.2f valid %x", w.FixType, w.Pdop, w.Valid)
+			}
+		})
+	}
+}
+
+func TestGSV(t *testing.T) {
+	type sys struct {
+		n       int
+		inview  uint8
+		tracked uint8
+		snr     uint8
+	}
+	tests := []struct {
+		name string
+		in   []string
+		want []sys
+	}{
+		{"GP", []string{
+			"GPGSV,2,1,06,05,40,100,40,13,30,200,35,15,20,300,,18,10,045,30",
+			"GPGSV,2,2,06,20,60,120,45,24,05,330,"},
+			[]sys{{SYS_GPS, 6, 4, 37}}},
+		{"incomplete sequence", []string{
+			"GPGSV,2,1,06,05,40,100,40,13,30,200,35,15,20,300,,18,10,045,30"},
+			[]sys{{SYS_GPS, 0, 0, 0}}},
+		{"GL", []string{"GLGSV,1,1,03,67,50,090,33,68,20,180,29,77,10,270,"},
+			[]sys{{SYS_GLONASS, 3, 2, 31}}},
+		{"GA", []string{"GAGSV,1,1,02,07,45,060,38,26,35,240,36"},
+			[]sys{{SYS_GALILEO, 2, 2, 37}}},
+		{"GB", []string{"GBGSV,1,1,01,19,70,150,41"},
+			[]sys{{SYS_BEIDOU, 1, 1, 41}}},
+		{"GN by first PRN", []string{"GNGSV,1,1,02,67,50,090,33,68,20,180,29"},
+			[]sys{{SYS_GLONASS, 2, 2, 31}}},
+		{"per constellation", []string{
+			"GPGSV,1,1,02,05,40,100,40,13,30,200,36",
+			"GLGSV,1,1,01,67,50,090,30"},
+			[]sys{{SYS_GPS, 2, 2, 38}, {SYS_GLONASS, 1, 1, 30}}},
+		// NMEA 4.1: a sequence per signal; the first signal is used
+		{"signal id", []string{
+			"GPGSV,1,1,03,05,40,100,42,13,30,200,38,15,20,300,,1",
+			"GPGSV,1,1,02,05,40,100,30,13,30,200,28,8",
+			"GAGSV,1,1,02,07,45,060,38,26,35,240,36,7"},
+			[]sys{{SYS_GPS, 3, 2, 40}, {SYS_GALILEO, 2, 2, 37}}},
+		{"signal id next epoch", []string{
+			"GPGSV,1,1,03,05,40,100,42,13,30,200,38,15,20,300,,1",
+			"GPGSV,1,1,02,05,40,100,30,13,30,200,28,8",
+			"GPGSV,1,1,03,05,40,100,44,13,30,200,40,15,20,300,20,1"},
+			[]sys{{SYS_GPS, 3, 3, 34}}},
+		{"none in view", []string{"GPGSV,1,1,00"},
+			[]sys{{SYS_GPS, 0, 0, 0}}},
+		{"short", []string{"GPGSV,1,1"},
+			[]sys{{SYS_GPS, 0, 0, 0}}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p := NewNMEAParser()
+			parse(p, tc.in...)
+			for _, s := range tc.want {
+				c := p.work.Sys[s.n]
+				if c.InView != s.inview || c.Tracked != s.tracked || c.Snr != s.snr {
+					t.Errorf("system %d: in view %d tracked %d snr %d, want %d %d %d",
+						s.n, c.InView, c.Tracked, c.Snr, s.inview, s.tracked, s.snr)
+				}
+			}
+		})
+	}
+}
+
+func TestVTG(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    string
+		hdg   float32
+		spd   float32
+		valid bool
+	}{
+		{"GP", "GPVTG,054.7,T,034.4,M,005.5,N,010.2,K,A", 54.7, 5.5, true},
+		{"GN", "GNVTG,270.0,T,,M,1.20,N,2.22,K,D", 270, 1.2, true},
+		{"empty", "GPVTG,,T,,M,,N,,K,N", 0, 0, false},
+		{"short", "GPVTG,054.7,T,034.4,M", 0, 0, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p := NewNMEAParser()
+			parse(p, "GPRMC,123000.00,V,,,,,,,171026,,,N", tc.in)
+			w := p.work
+			if (w.Valid&VALID_VEL != 0) != tc.valid || w.Hdg != tc.hdg || w.Spd != tc.spd {
+				t.Errorf("hdg %.1f spd %.1f valid %x", w.Hdg, w.Spd, w.Valid)
+			}
+		})
+	}
+}
+
+func TestGST(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    string
+		hacc  float64
+		vacc  float32
+		valid bool
+	}{
+		{"GP", "GPGST,172814.0,0.006,0.023,0.020,273.6,0.023,0.020,0.031", math.Hypot(0.023, 0.020), 0.031, true},
+		{"GN", "GNGST,123000.00,12,1.5,1.0,45.0,1.2,0.9,2.1", 1.5, 2.1, true},
+		{"empty", "GNGST,123000.00,,,,,,,", 0, 0, false},
+		{"short", "GPGST,172814.0,0.006,0.023", 0, 0, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p := NewNMEAParser()
+			parse(p, tc.in)
+			w := p.work
+			if (w.Valid&VALID_ACC != 0) != tc.valid || math.Abs(float64(w.HAcc)-tc.hacc) > 1e-4 || w.VAcc != tc.vacc {
+				t.Errorf("hacc %.4f vacc %.3f valid %x", w.HAcc, w.VAcc, w.Valid)
+			}
+		})
+	}
+}
+
+func TestZDA(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		date string
+	}{
+		{"GP", "GPZDA,201530.00,04,07,2002,00,00", "2002-07-04"},
+		{"GN", "GNZDA,123000.00,17,10,2026,,", "2026-10-17"},
+		{"empty", "GPZDA,201530.00,,,,,", ""},
+		{"short", "GPZDA,201530.00,04", ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p := NewNMEAParser()
+			parse(p, tc.in)
+			date := ""
+			if !p.date.IsZero() {
+				date = p.date.Format("2006-01-02")
+			}
+			if date != tc.date || (p.work.Valid&VALID_DATE != 0) != (tc.date != "") {
+				t.Errorf("date %q valid %x, want %q", date, p.work.Valid, tc.date)
+			}
+		})
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"123045", 12*time.Hour + 30*time.Minute + 45*time.Second},
+		{"123045.5", 12*time.Hour + 30*time.Minute + 45500*time.Millisecond},
+		{"123045.25", 12*time.Hour + 30*time.Minute + 45250*time.Millisecond},
+		{"000000.001", time.Millisecond},
+		{"235959.123456789", 24*time.Hour - time.Second + 123456789},
+		{"235959.1234567891", 24*time.Hour - time.Second + 123456789},
+		{"123045.", 12*time.Hour + 30*time.Minute + 45*time.Second},
+	}
+	for _, tc := range tests {
+		t.Run(tc.in, func(t *testing.T) {
+			if got := timeOfDay(parseTime(tc.in)); got != tc.want {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+	if !parseTime("1230").IsZero() {
+		t.Error("short time not zero")
+	}
+}
+
+func TestMidnight(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []string
+	}{
+		// the GGA of the new day comes before the RMC with its date
+		{"RMC", []string{
+			"GPGGA,235959.50,5006.0000,N,00100.0000,W,1,09,0.9,45.0,M,47.0,M,,",
+			"GPRMC,235959.50,A,5006.0000,N,00100.0000,W,2.92,0.0,171026,,,A",
+			"GPGGA,000000.50,5006.0000,N,00100.0000,W,1,09,0.9,45.0,M,47.0,M,,",
+			"GPRMC,000000.50,A,5006.0000,N,00100.0000,W,2.92,0.0,181026,,,A",
+			"GPGGA,000001.50,5006.0000,N,00100.0000,W,1,09,0.9,45.0,M,47.0,M,,"}},
+		// no date in the new day; the ZDA follows the GGA that completes
+		// the epoch
+		{"ZDA", []string{
+			"GPGGA,235958.50,5006.0000,N,00100.0000,W,1,09,0.9,45.0,M,47.0,M,,",
+			"GPGGA,235959.50,5006.0000,N,00100.0000,W,1,09,0.9,45.0,M,47.0,M,,",
+			"GPZDA,235959.50,17,10,2026,,",
+			"GPGGA,000000.50,5006.0000,N,00100.0000,W,1,09,0.9,45.0,M,47.0,M,,",
+			"GPGGA,000001.50,5006.0000,N,00100.0000,W,1,09,0.9,45.0,M,47.0,M,,"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fixes := parse(NewNMEAParser(), tc.in...)
+			// the first fix of the new day
+			j := 1
+			for j < len(fixes) && timeOfDay(fixes[j].Stamp) != 500*time.Millisecond {
+				j++
+			}
+			if j == len(fixes) {
+				t.Fatalf("no fix after midnight in %d", len(fixes))
+			}
+			want := time.Date(2026, 10, 18, 0, 0, 0, 500000000, time.UTC)
+			if f := fixes[j]; !f.Stamp.Equal(want) || f.Valid&VALID_DATE == 0 {
+				t.Errorf("stamp %v valid %x, want %v", f.Stamp, f.Valid, want)
+			}
+			if d := fixes[j].Sub(fixes[j-1]); d != time.Second {
+				t.Errorf("fixes %v apart", d)
+			}
+		})
+	}
+}
+
+func TestLateDate(t *testing.T) {
+	p := NewNMEAParser()
+	fixes := parse(p,
+		"GPGGA,235958.00,5006.0000,N,00100.0000,W,1,09,0.9,45.0,M,47.0,M,,",
+		"GPGGA,235959.00,5006.0000,N,00100.0000,W,1,09,0.9,45.0,M,47.0,M,,",
+		"GPGGA,000000.00,5006.0000,N,00100.0000,W,1,09,0.9,45.0,M,47.0,M,,",
+		"GPZDA,000000.00,18,10,2026,,",
+		"GPGGA,000001.00,5006.0000,N,00100.0000,W,1,09,0.9,45.0,M,47.0,M,,",
+		"GPGGA,000002.00,5006.0000,N,00100.0000,W,1,09,0.9,45.0,M,47.0,M,,")
+	// before the date, only the time of day
+	j := 0
+	for ; j < len(fixes) && fixes[j].Valid&VALID_DATE == 0; j++ {
+		if fixes[j].Stamp.Year() > 0 {
+			t.Errorf("stamp %v before the date", fixes[j].Stamp)
+		}
+	}
+	if j == 0 || j == len(fixes) {
+		t.Fatalf("%d of %d fixes without the date", j, len(fixes))
+	}
+	// the GGA completes the epoch before the ZDA, which dates the next
+	want := time.Date(2026, 10, 18, 0, 0, 1, 0, time.UTC)
+	if !fixes[j].Stamp.Equal(want) {
+		t.Errorf("first dated stamp %v, want %v", fixes[j].Stamp, want)
+	}
+	for j := 1; j < len(fixes); j++ {
+		if d := fixes[j].Sub(fixes[j-1]); d != time.Second {
+			t.Errorf("fix %d: %v after the previous", j, d)
+		}
+	}
+}
+
+func TestFixSub(t *testing.T) {
+	dated := func(h, m, s int) Fix {
+		return Fix{Stamp: time.Date(2026, 10, 17, h, m, s, 0, time.UTC), Valid: VALID_TIME | VALID_DATE}
+	}
+	tod := func(h, m, s int) Fix {
+		return Fix{Stamp: time.Date(0, 0, 0, h, m, s, 0, time.UTC), Valid: VALID_TIME}
+	}
+	tests := []struct {
+		name string
+		f, p Fix
+		want time.Duration
+	}{
+		{"dated", dated(12, 0, 1), dated(12, 0, 0), time.Second},
+		{"dated earlier", dated(12, 0, 0), dated(12, 0, 1), -time.Second},
+		{"dated a day apart", dated(12, 0, 0), Fix{Stamp: time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC), Valid: VALID_DATE}, 24 * time.Hour},
+		{"time of day", tod(12, 0, 1), tod(12, 0, 0), time.Second},
+		{"time of day midnight", tod(0, 0, 1), tod(23, 59, 59), 2 * time.Second},
+		{"time of day back over midnight", tod(23, 59, 59), tod(0, 0, 1), -2 * time.Second},
+		{"one dated", dated(12, 0, 2), tod(12, 0, 0), 2 * time.Second},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.f.Sub(tc.p); got != tc.want {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// epochBodies returns the sentences of the epoch at 12:30:ss, of the types
+// in order; "GSA" is a GSA per constellation (6 GPS, 4 GLONASS, 4 Galileo)
+func epochBodies(ss int, order ...string) []string {
+	return epochAt(time.Duration(ss)*time.Second, order...)
+}
+
+// epochAt returns the sentences of the epoch at 12:30:00 + t
+func epochAt(t time.Duration, order ...string) []string {
+	hms := time.Date(2026, 10, 17, 12, 30, 0, 0, time.UTC).Add(t).Format("150405.00")
+	var b []string
+	for _, typ := range order {
+		switch typ {
+		case "GGA":
+			b = append(b, "GNGGA,"+hms+",5006.0000,N,00100.0000,W,1,14,0.9,45.0,M,47.0,M,,")
+		case "RMC":
+			b = append(b, "GNRMC,"+hms+",A,5006.0000,N,00100.0000,W,2.92,45.0,171026,,,A")
+		case "VTG":
+			b = append(b, "GNVTG,45.0,T,,M,2.92,N,5.41,K,A")
+		case "GSA":
+			b = append(b,
+				"GNGSA,A,3,05,13,15,18,20,24,,,,,,,1.60,0.95,1.29,1",
+				"GNGSA,A,3,67,68,77,78,,,,,,,,,1.60,0.95,1.29,2",
+				"GNGSA,A,3,07,08,26,30,,,,,,,,,1.60,0.95,1.29,3")
+		case "GSV":
+			b = append(b, "GPGSV,1,1,02,05,40,100,40,13,30,200,36")
+		case "GST":
+			b = append(b, "GNGST,"+hms+",12,1.5,1.0,45.0,1.2,0.9,2.1")
+		case "ZDA":
+			b = append(b, "GNZDA,"+hms+",17,10,2026,,")
+		}
+	}
+	return b
+}
+
+func has(order []string, typ ...string) bool {
+	for _, o := range order {
+		for _, t := range typ {
+			if o == t {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestAssembler(t *testing.T) {
+	tests := []struct {
+		name  string
+		order []string
+		n     int // fixes out of 4 epochs; the last waits for a GSA run to end
+		first int // the first epoch emitted; a single sentence supersedes the first
+	}{
+		{"GGA RMC", []string{"GGA", "RMC"}, 4, 0},
+		{"RMC GGA", []string{"RMC", "GGA"}, 4, 0},
+		{"GGA only", []string{"GGA"}, 3, 1},
+		{"RMC only", []string{"RMC"}, 3, 1},
+		{"GGA RMC GSA", []string{"GGA", "RMC", "GSA"}, 3, 0},
+		{"multi GSA last", []string{"RMC", "VTG", "GGA", "GSA"}, 3, 0},
+		{"multi GSA then GSV", []string{"RMC", "VTG", "GGA", "GSA", "GSV"}, 4, 0},
+		{"multi GSA mid", []string{"GGA", "GSA", "RMC"}, 4, 0},
+		{"missing GGA", []string{"RMC", "VTG", "GSA", "GSV"}, 4, 0},
+		{"missing RMC", []string{"GGA", "VTG", "GSA", "GSV"}, 4, 0},
+		{"ZDA", []string{"ZDA", "GGA", "RMC", "GSA", "GSV"}, 4, 0},
+		{"GST last", []string{"RMC", "GGA", "GSA", "GST"}, 4, 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p := NewNMEAParser()
+			var fixes []Fix
+			for ss := 0; ss < 4; ss++ {
+				fixes = append(fixes, parse(p, epochBodies(ss, tc.order...)...)...)
+			}
+			if len(fixes) != tc.n {
+				t.Fatalf("got %d fixes, want %d", len(fixes), tc.n)
+			}
+			for j, f := range fixes {
+				if d := timeOfDay(f.Stamp) - (12*time.Hour + 30*time.Minute); d != time.Duration(j+tc.first)*time.Second {
+					t.Errorf("fix %d at %v", j, d)
+				}
+				if f.Quality != 1 || f.Valid&VALID_POS == 0 {
+					t.Errorf("fix %d: quality %d valid %x", j, f.Quality, f.Valid)
+				}
+				gsa := has(tc.order, "GSA")
+				if gsa || has(tc.order, "GGA") {
+					if f.Sats != 14 {
+						t.Errorf("fix %d: %d sats", j, f.Sats)
+					}
+				}
+				if gsa {
+					used := [3]uint8{f.Sys[SYS_GPS].Used, f.Sys[SYS_GLONASS].Used, f.Sys[SYS_GALILEO].Used}
+					if used != [3]uint8{6, 4, 4} || f.Valid&VALID_DOP == 0 {
+						t.Errorf("fix %d: used %v valid %x", j, used, f.Valid)
+					}
+				}
+				if vel := has(tc.order, "RMC", "VTG"); (f.Valid&VALID_VEL != 0) != vel {
+					t.Errorf("fix %d: valid %x, want velocity %v", j, f.Valid, vel)
+				}
+			}
+		})
+	}
+}
+
+// an epoch missing its completing sentence is emitted when the next starts
+func TestAssemblerDropped(t *testing.T) {
+	p := NewNMEAParser()
+	var fixes []Fix
+	for ss, order := range [][]string{{"GGA", "RMC"}, {"GGA", "RMC"}, {"GGA"}, {"GGA", "RMC"}} {
+		fixes = append(fixes, parse(p, epochBodies(ss, order...)...)...)
+		if ss == 2 && len(fixes) != 2 {
+			t.Errorf("incomplete epoch emitted early")
+		}
+	}
+	if len(fixes) != 4 {
+		t.Fatalf("got %d fixes, want 4", len(fixes))
+	}
+	if f := fixes[2]; f.Valid&VALID_VEL != 0 || f.Valid&VALID_POS == 0 {
+		t.Errorf("epoch without RMC: valid %x", f.Valid)
+	}
+}
+
+// each epoch is emitted by its own sentences, once the first has been
+// emitted as the second starts
+func TestAssemblerLatency(t *testing.T) {
+	tests := []struct {
+		name  string
+		order []string
+	}{
+		{"GGA RMC", []string{"GGA", "RMC"}},
+		{"GGA only", []string{"GGA"}},
+		{"multi GSA then GSV", []string{"RMC", "VTG", "GGA", "GSA", "GSV"}},
+		{"GST last", []string{"RMC", "GGA", "GSA", "GST"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p := NewNMEAParser()
+			if fixes := parse(p, epochBodies(0, tc.order...)...); len(fixes) != 0 {
+				t.Errorf("first epoch emitted without a terminator")
+			}
+			for ss := 1; ss < 4; ss++ {
+				fixes := parse(p, epochBodies(ss, tc.order...)...)
+				if len(fixes) == 0 {
+					t.Fatalf("epoch %d not emitted", ss)
+				}
+				if f := fixes[len(fixes)-1]; f.Stamp.Second() != ss {
+					t.Errorf("epoch %d: fix at %v", ss, f.Stamp)
+				}
+			}
+		})
+	}
+}
+
+// a 1 Hz GST does not hold back 5 Hz epochs; a GST following the
+// terminator is dropped, one preceding it is kept
+func TestAssemblerSlowGST(t *testing.T) {
+	tests := []struct {
+		name  string
+		order []string
+		gst   []string
+		wait  bool // the terminator is a GSA run, ended by the next epoch
+		acc   bool
+	}{
+		{"after GSA", []string{"RMC", "VTG", "GGA", "GSA"},
+			[]string{"RMC", "VTG", "GGA", "GSA", "GST"}, true, false},
+		{"after GSV", []string{"RMC", "VTG", "GGA", "GSA", "GSV"},
+			[]string{"RMC", "VTG", "GGA", "GSA", "GSV", "GST"}, false, false},
+		{"before RMC", []string{"GGA", "RMC"}, []string{"GGA", "GST", "RMC"}, false, true},
+	}
+	const epoch = 200 * time.Millisecond
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p := NewNMEAParser()
+			emitted := make(map[time.Duration]int)
+			for j := 0; j < 16; j++ {
+				order := tc.order
+				if j%5 == 0 {
+					order = tc.gst
+				}
+				fixes := parse(p, epochAt(time.Duration(j)*epoch, order...)...)
+				for _, f := range fixes {
+					d := timeOfDay(f.Stamp) - (12*time.Hour + 30*time.Minute)
+					emitted[d]++
+					k := int(d / epoch)
+					if acc := f.Valid&VALID_ACC != 0; k >= 4 && acc != (tc.acc && k%5 == 0) {
+						t.Errorf("epoch %d: accuracy %v", k, acc)
+					}
+				}
+				// the terminator is learnt by the third epoch
+				if j >= 4 && !tc.wait {
+					if len(fixes) != 1 || fixes[0].Stamp.Nanosecond() != int(time.Duration(j)*epoch%time.Second) {
+						t.Errorf("epoch %d: %d fixes, not emitted by its own sentences", j, len(fixes))
+					}
+				}
+			}
+			for j := 3; j < 15; j++ {
+				if n := emitted[time.Duration(j)*epoch]; n != 1 {
+					t.Errorf("epoch %d emitted %d times", j, n)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package gps
+
+// Parser accepts a mixture of NMEA and UBX
+type Parser struct {
+	Fix  Fix
+	NMEA *NMEAParser
+	UBX  *UBXParser
+}
+
+func NewParser() *Parser {
+	return &Parser{NMEA: NewNMEAParser(), UBX: NewUBXParser()}
+}
+
+// Parse consumes a byte of NMEA or UBX; it returns true when a new fix is
+// available in r.Fix
+func (r *Parser) Parse(c byte) bool {
+	ok := false
+	if r.UBX.Parse(c) {
+		r.Fix = r.UBX.Fix
+		ok = true
+	}
+	if r.NMEA.Parse(c) {
+		r.Fix = r.NMEA.Fix
+		ok = true
+	}
+	return ok
+}
@@ -0,0 +1,244 @@
+package gps
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// Detected protocols
+const (
+	PROTO_NONE = iota
+	PROTO_NMEA
+	PROTO_UBX
+)
+
+// Receiver types, for configuration
+const (
+	RX_NONE = iota
+	RX_UBLOX
+	RX_MTK
+	RX_QUECTEL
+)
+
+// Constellations (Receiver.GNSS); 0 leaves the receiver default
+const (
+	GNSS_GPS = 1 << iota
+	GNSS_GLONASS
+	GNSS_GALILEO
+	GNSS_BEIDOU
+)
+
+// Baud rates probed, after the configured rate
+var ProbeBauds = []uint32{9600, 38400, 115200, 57600, 19200, 4800}
+
+// Time spent listening at each probed baud rate
+const PROBE_DWELL = 1500 * time.Millisecond
+
+var protoNames = [...]string{"none", "NMEA", "UBX"}
+var rxNames = [...]string{"none", "ublox", "mtk", "quectel"}
+
+func ProtoName(p int) string {
+	if p >= 0 && p < len(protoNames) {
+		return protoNames[p]
+	}
+	return "?"
+}
+
+func ReceiverName(t int) string {
+	if t >= 0 && t < len(rxNames) {
+		return rxNames[t]
+	}
+	return "?"
+}
+
+// ParseReceiver accepts a receiver name or number
+func ParseReceiver(s string) (int, error) {
+	for j, n := range rxNames {
+		if s == n || (len(s) == 1 && s[0] == byte('0'+j)) {
+			return j, nil
+		}
+	}
+	return RX_NONE, errors.New("unknown receiver " + s)
+}
+
+// Port is the receiver connection used by Probe and Configure; ReadFor
+// reads (and parses) input for d, delivering any fixes as usual
+type Port interface {
+	SetBaud(baud uint32)
+	Write(b []byte) (int, error)
+	ReadFor(d time.Duration)
+}
+
+// Status reports the progress of Probe and Configure
+type Status struct {
+	Baud  uint32
+	Proto int // PROTO_NONE while probing
+	Text  string
+}
+
+func (r *Parser) counts() (uint32, uint32) {
+	return r.NMEA.Sentences, r.UBX.Frames
+}
+
+// Probe listens at baud, then each of ProbeBauds in turn (repeatedly),
+// until valid NMEA (two sentences) or UBX (one frame) is seen. Progress is
+// sent to report (if not nil). The port is left at the detected rate.
+func Probe(p Port, r *Parser, baud uint32, report func(Status)) Status {
+	rates := []uint32{baud}
+	for _, b := range ProbeBauds {
+		if b != baud {
+			rates = append(rates, b)
+		}
+	}
+	for j := 0; ; j = (j + 1) % len(rates) {
+		b := rates[j]
+		if report != nil {
+			report(Status{Baud: b, Text: itoa(b) + "?"})
+		}
+		p.SetBaud(b)
+		n0, u0 := r.counts()
+		p.ReadFor(PROBE_DWELL)
+		n, u := r.counts()
+		proto := PROTO_NONE
+		if u-u0 > 0 {
+			proto = PROTO_UBX
+		} else if n-n0 > 1 {
+			proto = PROTO_NMEA
+		}
+		if proto != PROTO_NONE {
+			st := Status{Baud: b, Proto: proto, Text: itoa(b) + " " + ProtoName(proto)}
+			if report != nil {
+				report(st)
+			}
+			return st
+		}
+	}
+}
+
+// Receiver describes the configuration to apply to a receiver
+type Receiver struct {
+	Type  int    // RX_*
+	Baud  uint32 // required baud rate
+	Rate  int    // Hz, 0 leaves the receiver default
+	GNSS  int    // GNSS_* mask, 0 leaves the receiver default
+	PVT   bool   // u-blox: NAV-PVT, else the legacy messages
+	Model byte   // u-blox: dynamic model
+}
+
+// Commands returns the commands to configure the receiver, all sent at
+// the current baud rate; the last changes the baud rate to r.Baud
+func (r Receiver) Commands() [][]byte {
+	switch r.Type {
+	case RX_UBLOX:
+		cfg := UBXConfig(r.Baud, r.Rate, r.PVT, r.Model)
+		if r.GNSS != 0 {
+			n := len(cfg) - 1
+			cfg = append(cfg[:n], UBXCfgGNSS(r.GNSS), cfg[n])
+		}
+		return cfg
+	case RX_MTK, RX_QUECTEL:
+		var cfg [][]byte
+		if r.Rate > 0 {
+			cfg = append(cfg, NMEACommand("PMTK220,"+strconv.Itoa(1000/r.Rate)))
+		}
+		// RMC, GGA, GSA each fix, GSV every fifth
+		cfg = append(cfg, NMEACommand("PMTK314,0,1,0,1,1,5,0,0,0,0,0,0,0,0,0,0,0,0,0"))
+		if r.GNSS != 0 {
+			cfg = append(cfg, NMEACommand("PMTK353,"+gnssFlag(r.GNSS, GNSS_GPS)+
+				","+gnssFlag(r.GNSS, GNSS_GLONASS)+","+gnssFlag(r.GNSS, GNSS_GALILEO)+
+				",0,"+gnssFlag(r.GNSS, GNSS_BEIDOU)))
+		}
+		if r.Type == RX_MTK {
+			cfg = append(cfg, NMEACommand("PMTK251,"+itoa(r.Baud)))
+		} else {
+			cfg = append(cfg, NMEACommand("PQBAUD,W,"+itoa(r.Baud)))
+		}
+		return cfg
+	}
+	return nil
+}
+
+// NMEACommand adds the delimiters and checksum to a proprietary sentence
+func NMEACommand(s string) []byte {
+	cs := byte(0)
+	for j := 0; j < len(s); j++ {
+		cs ^= s[j]
+	}
+	const hex = "0123456789ABCDEF"
+	return []byte("$" + s + "*" + string([]byte{hex[cs>>4], hex[cs&15]}) + "\r\n")
+}
+
+func itoa(v uint32) string {
+	return strconv.FormatUint(uint64(v), 10)
+}
+
+func gnssFlag(mask, flag int) string {
+	if mask&flag != 0 {
+		return "1"
+	}
+	return "0"
+}
+
+// UBXCfgGNSS enables the constellations of mask (others are disabled);
+// not supported by u-blox 6 and earlier
+func UBXCfgGNSS(mask int) []byte {
+	blocks := []struct {
+		flag, id, res, max byte
+	}{
+		{GNSS_GPS, 0, 8, 16},
+		{GNSS_GALILEO, 2, 4, 8},
+		{GNSS_BEIDOU, 3, 8, 16},
+		{GNSS_GLONASS, 6, 8, 14},
+	}
+	p := make([]byte, 4, 4+8*len(blocks))
+	p[2] = 0xff
+	p[3] = byte(len(blocks))
+	for _, b := range blocks {
+		blk := make([]byte, 8)
+		blk[0] = b.id
+		blk[1] = b.res
+		blk[2] = b.max
+		flags := uint32(0x010000)
+		if mask&int(b.flag) != 0 {
+			flags |= 1
+		}
+		binary.LittleEndian.PutUint32(blk[4:], flags)
+		p = append(p, blk...)
+	}
+	return UBXFrame(UBX_CLASS_CFG, UBX_CFG_GNSS, p)
+}
+
+// Configure sends the receiver configuration, switching the port to the
+// new baud rate before the last command is acted upon. For u-blox, the
+// acknowledgements are counted; the result is sent to report (if not nil).
+func Configure(p Port, r *Parser, rx Receiver, report func(Status)) Status {
+	cmds := rx.Commands()
+	st := Status{Baud: rx.Baud, Text: "no config"}
+	if len(cmds) == 0 {
+		return st
+	}
+	a0 := r.UBX.Acks
+	for j, b := range cmds {
+		p.Write(b)
+		if j == len(cmds)-1 {
+			// allow the command to be transmitted at the old rate
+			p.ReadFor(100 * time.Millisecond)
+			p.SetBaud(rx.Baud)
+		}
+		p.ReadFor(100 * time.Millisecond)
+	}
+	p.ReadFor(500 * time.Millisecond)
+	st.Text = ReceiverName(rx.Type) + " " + itoa(rx.Baud)
+	if rx.Rate > 0 {
+		st.Text += " " + strconv.Itoa(rx.Rate) + "Hz"
+	}
+	if rx.Type == RX_UBLOX {
+		st.Text += " " + itoa(r.UBX.Acks-a0) + "/" + strconv.Itoa(len(cmds)) + " ack"
+	}
+	if report != nil {
+		report(st)
+	}
+	return st
+}
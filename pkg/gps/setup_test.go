@@ -0,0 +1,217 @@
+package gps
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeRx is a Port to a receiver that is only understood at its own baud
+// rate; it acknowledges UBX CFG messages and follows baud rate changes
+type fakeRx struct {
+	p      *Parser
+	baud   uint32 // port
+	rxbaud uint32 // receiver
+	out    []byte // output each ReadFor
+	ack    bool
+	queue  []byte
+	bauds  []uint32
+	writes [][]byte
+}
+
+// what a receiver at another baud rate looks like
+var noise = []byte{0x00, 0xf8, 0x80, '$', 0x78, 0xe0, 0xb5, 0x00, 0xfe, 0x86, 0x0a}
+
+func (f *fakeRx) SetBaud(b uint32) {
+	f.baud = b
+	f.bauds = append(f.bauds, b)
+}
+
+func (f *fakeRx) Write(b []byte) (int, error) {
+	f.writes = append(f.writes, b)
+	if f.baud != f.rxbaud {
+		return len(b), nil
+	}
+	s := string(b)
+	switch {
+	case len(b) > 8 && b[0] == UBX_SYNC1 && b[2] == UBX_CLASS_CFG:
+		if f.ack {
+			f.queue = append(f.queue, UBXFrame(UBX_CLASS_ACK, UBX_ACK_ACK, b[2:4])...)
+		}
+		if b[3] == UBX_CFG_PRT {
+			f.rxbaud = binary.LittleEndian.Uint32(b[6+8:])
+		}
+	case strings.HasPrefix(s, "$PMTK251,"), strings.HasPrefix(s, "$PQBAUD,W,"):
+		v, _ := strconv.Atoi(s[strings.LastIndexByte(s, ',')+1 : strings.IndexByte(s, '*')])
+		f.rxbaud = uint32(v)
+	}
+	return len(b), nil
+}
+
+func (f *fakeRx) ReadFor(d time.Duration) {
+	in := noise
+	if f.baud == f.rxbaud {
+		in = append(f.queue, f.out...)
+		f.queue = nil
+	}
+	for _, c := range in {
+		f.p.Parse(c)
+	}
+}
+
+const (
+	gga = "$GPGGA,123000.00,5006.0000,N,00100.0000,W,1,09,0.9,45.0,M,47.0,M,,*4E\r\n"
+	rmc = "$GPRMC,123000.00,A,5006.0000,N,00100.0000,W,2.92,0.0,171026,,,A*74\r\n"
+)
+
+func TestProbe(t *testing.T) {
+	tests := []struct {
+		name   string
+		rxbaud uint32
+		out    []byte
+		proto  int
+		bauds  []uint32
+	}{
+		{"configured rate", 9600, []byte(gga + rmc), PROTO_NMEA, []uint32{9600}},
+		{"nmea 38400", 38400, []byte(gga + rmc), PROTO_NMEA, []uint32{9600, 38400}},
+		{"ubx 57600", 57600, navPVT(testItow), PROTO_UBX, []uint32{9600, 38400, 115200, 57600}},
+		{"nmea 4800", 4800, []byte(gga + rmc), PROTO_NMEA, []uint32{9600, 38400, 115200, 57600, 19200, 4800}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f := &fakeRx{p: NewParser(), rxbaud: tc.rxbaud, out: tc.out}
+			var reports []Status
+			st := Probe(f, f.p, 9600, func(s Status) { reports = append(reports, s) })
+			if st.Baud != tc.rxbaud || st.Proto != tc.proto {
+				t.Errorf("got %d %s, want %d %s", st.Baud, ProtoName(st.Proto), tc.rxbaud, ProtoName(tc.proto))
+			}
+			if !equal32(f.bauds, tc.bauds) {
+				t.Errorf("probed %v, want %v", f.bauds, tc.bauds)
+			}
+			if f.baud != tc.rxbaud {
+				t.Errorf("port left at %d", f.baud)
+			}
+			if n := len(reports); n != len(tc.bauds)+1 || reports[n-1] != st {
+				t.Errorf("reports %v", reports)
+			}
+		})
+	}
+}
+
+func equal32(a, b []uint32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for j := range a {
+		if a[j] != b[j] {
+			return false
+		}
+	}
+	return true
+}
+
+func hexBytes(s string) []byte {
+	var b []byte
+	for _, h := range strings.Fields(s) {
+		v, _ := strconv.ParseUint(h, 16, 8)
+		b = append(b, byte(v))
+	}
+	return b
+}
+
+func TestCommands(t *testing.T) {
+	nav5 := "B5 62 06 24 24 00 01 00 03" + strings.Repeat(" 00", 33) + " 52 4E"
+	tests := []struct {
+		name string
+		rx   Receiver
+		want [][]byte
+	}{
+		{"none", Receiver{Type: RX_NONE, Baud: 115200}, nil},
+		{"ublox", Receiver{Type: RX_UBLOX, Baud: 115200, Rate: 5, PVT: true, Model: DYN_PEDESTRIAN}, [][]byte{
+			hexBytes("B5 62 06 08 06 00 C8 00 01 00 01 00 DE 6A"), // CFG-RATE 200ms
+			hexBytes(nav5), // CFG-NAV5 pedestrian
+			hexBytes("B5 62 06 01 03 00 01 04 01 10 4B"), // NAV-DOP on
+			hexBytes("B5 62 06 01 03 00 01 07 01 13 51"), // NAV-PVT on
+			hexBytes("B5 62 06 01 03 00 01 02 00 0D 46"), // NAV-POSLLH off
+			hexBytes("B5 62 06 01 03 00 01 12 00 1D 66"), // NAV-VELNED off
+			hexBytes("B5 62 06 01 03 00 01 06 00 11 4E"), // NAV-SOL off
+			// CFG-PRT UART1 115200 8N1, in UBX+NMEA, out UBX
+			hexBytes("B5 62 06 00 14 00 01 00 00 00 D0 08 00 00 00 C2 01 00 03 00 01 00 00 00 00 00 BA 52"),
+		}},
+		{"mtk", Receiver{Type: RX_MTK, Baud: 115200, Rate: 5, GNSS: GNSS_GPS | GNSS_GLONASS}, [][]byte{
+			[]byte("$PMTK220,200*2C\r\n"),
+			[]byte("$PMTK314,0,1,0,1,1,5,0,0,0,0,0,0,0,0,0,0,0,0,0*2C\r\n"),
+			[]byte("$PMTK353,1,1,0,0,0*2B\r\n"),
+			[]byte("$PMTK251,115200*1F\r\n"),
+		}},
+		{"quectel", Receiver{Type: RX_QUECTEL, Baud: 115200, Rate: 10}, [][]byte{
+			[]byte("$PMTK220,100*2F\r\n"),
+			[]byte("$PMTK314,0,1,0,1,1,5,0,0,0,0,0,0,0,0,0,0,0,0,0*2C\r\n"),
+			[]byte("$PQBAUD,W,115200*43\r\n"),
+		}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := tc.rx.Commands()
+			if len(got) != len(tc.want) {
+				t.Fatalf("got %d commands, want %d", len(got), len(tc.want))
+			}
+			for j := range got {
+				if !bytes.Equal(got[j], tc.want[j]) {
+					t.Errorf("command %d: got % X, want % X", j, got[j], tc.want[j])
+				}
+			}
+		})
+	}
+
+	// CFG-GNSS goes before the port change
+	cmds := Receiver{Type: RX_UBLOX, Baud: 115200, GNSS: GNSS_GPS | GNSS_GALILEO}.Commands()
+	if n := len(cmds); n != 9 || cmds[n-2][3] != UBX_CFG_GNSS || cmds[n-1][3] != UBX_CFG_PRT {
+		t.Errorf("u-blox with GNSS: %d commands", n)
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	tests := []struct {
+		name string
+		rx   Receiver
+		ack  bool
+		text string
+	}{
+		{"ublox", Receiver{Type: RX_UBLOX, Baud: 115200, Rate: 5, PVT: true}, true, "ublox 115200 5Hz 8/8 ack"},
+		{"ublox no ack", Receiver{Type: RX_UBLOX, Baud: 57600}, false, "ublox 57600 0/8 ack"},
+		{"mtk", Receiver{Type: RX_MTK, Baud: 38400, Rate: 5}, false, "mtk 38400 5Hz"},
+		{"quectel", Receiver{Type: RX_QUECTEL, Baud: 115200}, false, "quectel 115200"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f := &fakeRx{p: NewParser(), rxbaud: 9600, ack: tc.ack}
+			f.SetBaud(9600)
+			var rep Status
+			st := Configure(f, f.p, tc.rx, func(s Status) { rep = s })
+			if st.Text != tc.text || rep != st {
+				t.Errorf("got %q (reported %q), want %q", st.Text, rep.Text, tc.text)
+			}
+			cmds := tc.rx.Commands()
+			if len(f.writes) != len(cmds) {
+				t.Fatalf("wrote %d commands, want %d", len(f.writes), len(cmds))
+			}
+			for j := range cmds {
+				if !bytes.Equal(f.writes[j], cmds[j]) {
+					t.Errorf("command %d: wrote % X", j, f.writes[j])
+				}
+			}
+			if f.rxbaud != tc.rx.Baud || f.baud != tc.rx.Baud {
+				t.Errorf("receiver at %d, port at %d, want %d", f.rxbaud, f.baud, tc.rx.Baud)
+			}
+		})
+	}
+
+	f := &fakeRx{p: NewParser(), rxbaud: 9600}
+	if st := Configure(f, f.p, Receiver{Type: RX_NONE, Baud: 9600}, nil); st.Text != "no config" || len(f.writes) != 0 {
+		t.Errorf("RX_NONE: %q, %d writes", st.Text, len(f.writes))
+	}
+}
@@ -0,0 +1,439 @@
+package gps
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+/* u-blox UBX binary protocol. The parser accepts either NAV-PVT (u-blox 7
+ * and later) or the older NAV-POSLLH / NAV-VELNED / NAV-SOL / NAV-DOP set
+ * (e.g. Neo-6M). Messages of the same navigation epoch (iTOW) are merged
+ * into one fix. */
+
+const (
+	UBX_SYNC1 = 0xb5
+	UBX_SYNC2 = 0x62
+
+	UBX_CLASS_NAV  = 0x01
+	UBX_CLASS_ACK  = 0x05
+	UBX_CLASS_CFG  = 0x06
+	UBX_CLASS_NMEA = 0xf0
+
+	UBX_NAV_POSLLH = 0x02
+	UBX_NAV_DOP    = 0x04
+	UBX_NAV_SOL    = 0x06
+	UBX_NAV_PVT    = 0x07
+	UBX_NAV_VELNED = 0x12
+
+	UBX_ACK_NAK = 0x00
+	UBX_ACK_ACK = 0x01
+
+	UBX_CFG_PRT  = 0x00
+	UBX_CFG_MSG  = 0x01
+	UBX_CFG_RATE = 0x08
+	UBX_CFG_NAV5 = 0x24
+	UBX_CFG_GNSS = 0x3e
+
+	// CFG-NAV5 dynamic models
+	DYN_PORTABLE   = 0
+	DYN_STATIONARY = 2
+	DYN_PEDESTRIAN = 3
+	DYN_AUTOMOTIVE = 4
+
+	UBX_MAX_PAYLOAD = 100
+	GPS_LEAP        = 18 * time.Second
+	KNOTS_TO_MS     = 1852.0 / 3600.0
+)
+
+const (
+	u_SYNC1 = iota
+	u_SYNC2
+	u_CLASS
+	u_ID
+	u_LEN1
+	u_LEN2
+	u_PAYLOAD
+	u_CKA
+	u_CKB
+)
+
+var gpsEpoch = time.Date(1980, 1, 6, 0, 0, 0, 0, time.UTC)
+
+// UBXAck is the last ACK-ACK or ACK-NAK received
+type UBXAck struct {
+	Class byte
+	Id    byte
+	Ok    bool
+}
+
+type UBXParser struct {
+	Fix     Fix
+	Ack     UBXAck
+	Acks    uint32 // incremented for each ACK / NAK
+	Frames  uint32 // valid frames, of any class
+	state   int
+	class   byte
+	id      byte
+	length  int
+	n       int
+	cka     byte
+	ckb     byte
+	payload []byte
+	work    Fix
+	itow    uint32
+	week    int
+	maxid   byte // highest NAV id seen in this epoch
+	last    byte // last NAV id of an epoch
+	started bool
+	emitted bool
+	dop     bool
+}
+
+func NewUBXParser() *UBXParser {
+	return &UBXParser{payload: make([]byte, UBX_MAX_PAYLOAD)}
+}
+
+// Parse consumes a byte of UBX; it returns true when a message completes a
+// new fix, which is then available in r.Fix
+func (r *UBXParser) Parse(c byte) bool {
+	switch r.state {
+	case u_SYNC1:
+		if c == UBX_SYNC1 {
+			r.state = u_SYNC2
+		}
+		return false
+	case u_SYNC2:
+		if c == UBX_SYNC2 {
+			r.state = u_CLASS
+			r.cka = 0
+			r.ckb = 0
+		} else {
+			r.state = u_SYNC1
+		}
+		return false
+	case u_CKA:
+		if c == r.cka {
+			r.state = u_CKB
+		} else {
+			r.state = u_SYNC1
+		}
+		return false
+	case u_CKB:
+		r.state = u_SYNC1
+		if c == r.ckb {
+			r.Frames++
+			if r.length <= UBX_MAX_PAYLOAD {
+				return r.message(r.payload[:r.length])
+			}
+		}
+		return false
+	}
+
+	r.cka += c
+	r.ckb += r.cka
+	switch r.state {
+	case u_CLASS:
+		r.class = c
+		r.state = u_ID
+	case u_ID:
+		r.id = c
+		r.state = u_LEN1
+	case u_LEN1:
+		r.length = int(c)
+		r.state = u_LEN2
+	case u_LEN2:
+		r.length |= int(c) << 8
+		r.n = 0
+		if r.length == 0 {
+			r.state = u_CKA
+		} else {
+			r.state = u_PAYLOAD
+		}
+	case u_PAYLOAD:
+		// oversize messages are checked, but not stored
+		if r.n < UBX_MAX_PAYLOAD {
+			r.payload[r.n] = c
+		}
+		r.n++
+		if r.n == r.length {
+			r.state = u_CKA
+		}
+	}
+	return false
+}
+
+func u16(b []byte) uint16 {
+	return binary.LittleEndian.Uint16(b)
+}
+
+func u32(b []byte) uint32 {
+	return binary.LittleEndian.Uint32(b)
+}
+
+func i32(b []byte) int32 {
+	return int32(binary.LittleEndian.Uint32(b))
+}
+
+// towTime converts GPS time of week (ms) to UTC; without the week, only the
+// time of day is known (as for NMEA)
+func towTime(week int, itow uint32) time.Time {
+	t := time.Duration(itow)*time.Millisecond - GPS_LEAP
+	if week > 0 {
+		return gpsEpoch.Add(time.Duration(week)*7*24*time.Hour + t)
+	}
+	day := 24 * time.Hour
+	return time.Date(0, 0, 0, 0, 0, 0, 0, time.UTC).Add((t%day + day) % day)
+}
+
+func ubxQuality(fixtype byte, ok bool, diff bool) uint8 {
+	if !ok || fixtype < 2 || fixtype > 4 {
+		return 0
+	}
+	if diff {
+		return 2
+	}
+	return 1
+}
+
+// emit publishes the epoch's fix
+func (r *UBXParser) emit() {
+	r.Fix = r.work
+	r.Fix.checkValid()
+	r.Fix.Rx = time.Now()
+}
+
+// ubxFixType maps a UBX fix type to that of NMEA GSA
+func ubxFixType(fixtype byte) uint8 {
+	switch fixtype {
+	case 2:
+		return 2
+	case 3, 4:
+		return 3
+	}
+	return 1
+}
+
+func (r *UBXParser) setLatLon(lat, lon int32) {
+	r.work.Lat7 = lat
+	r.work.Lon7 = lon
+	r.work.Lat = float32(float64(lat) / 1e7)
+	r.work.Lon = float32(float64(lon) / 1e7)
+}
+
+func (r *UBXParser) setVel(n, e, d int32, gspeed int32, heading int32, scale float32) {
+	r.work.VelN = float32(n) * scale
+	r.work.VelE = float32(e) * scale
+	r.work.VelD = float32(d) * scale
+	r.work.Spd = float32(gspeed) * scale / KNOTS_TO_MS
+	r.work.Hdg = float32(heading) / 1e5
+}
+
+func (r *UBXParser) message(p []byte) bool {
+	if r.class == UBX_CLASS_ACK && len(p) >= 2 {
+		r.Ack = UBXAck{Class: p[0], Id: p[1], Ok: r.id == UBX_ACK_ACK}
+		r.Acks++
+		return false
+	}
+	if r.class != UBX_CLASS_NAV || len(p) < 4 {
+		return false
+	}
+
+	done := false
+	itow := u32(p)
+	if !r.started || itow != r.itow {
+		// a new epoch; if the previous was not complete, emit it now
+		if r.started && !r.emitted && r.maxid != 0 {
+			r.emit()
+			r.last = r.maxid
+			done = true
+		}
+		r.started = true
+		r.itow = itow
+		r.maxid = 0
+		r.emitted = false
+		r.work.Stamp = towTime(r.week, itow)
+		r.work.Valid = VALID_TIME
+		if r.week > 0 {
+			r.work.Valid |= VALID_DATE
+		}
+	}
+
+	switch r.id {
+	case UBX_NAV_PVT:
+		if len(p) < 92 {
+			return done
+		}
+		valid := p[11]
+		if valid&3 == 3 {
+			r.work.Stamp = time.Date(int(u16(p[4:])), time.Month(p[6]), int(p[7]),
+				int(p[8]), int(p[9]), int(p[10]), 0, time.UTC).Add(time.Duration(i32(p[16:])))
+			r.work.Valid |= VALID_DATE
+		}
+		r.work.Valid |= VALID_POS | VALID_ALT | VALID_SATS | VALID_VEL | VALID_NED | VALID_DOP | VALID_ACC
+		r.work.Quality = ubxQuality(p[20], p[21]&1 == 1, p[21]&2 == 2)
+		r.work.FixType = ubxFixType(p[20])
+		r.work.Sats = p[23]
+		r.setLatLon(i32(p[28:]), i32(p[24:]))
+		r.work.Alt = float32(i32(p[36:])) / 1000
+		r.work.HAcc = float32(u32(p[40:])) / 1000
+		r.work.VAcc = float32(u32(p[44:])) / 1000
+		r.setVel(i32(p[48:]), i32(p[52:]), i32(p[56:]), i32(p[60:]), i32(p[64:]), 0.001)
+		r.work.SAcc = float32(u32(p[68:])) / 1000
+		r.work.Pdop = float32(u16(p[76:])) / 100
+		if !r.dop {
+			// no NAV-DOP, PDOP is a (pessimistic) HDOP
+			r.work.Hdop = r.work.Pdop
+		}
+		// a complete solution
+		r.emit()
+		r.emitted = true
+		return true
+
+	case UBX_NAV_POSLLH:
+		if len(p) < 28 {
+			return done
+		}
+		r.setLatLon(i32(p[8:]), i32(p[4:]))
+		r.work.Alt = float32(i32(p[16:])) / 1000
+		r.work.HAcc = float32(u32(p[20:])) / 1000
+		r.work.VAcc = float32(u32(p[24:])) / 1000
+		r.work.Valid |= VALID_POS | VALID_ALT | VALID_ACC
+
+	case UBX_NAV_VELNED:
+		if len(p) < 36 {
+			return done
+		}
+		r.setVel(i32(p[4:]), i32(p[8:]), i32(p[12:]), int32(u32(p[20:])), i32(p[24:]), 0.01)
+		r.work.SAcc = float32(u32(p[28:])) / 100
+		r.work.Valid |= VALID_VEL | VALID_NED
+
+	case UBX_NAV_SOL:
+		if len(p) < 52 {
+			return done
+		}
+		// week number valid (WKNSET) and time of week valid (TOWSET)
+		if p[11]&0x0c == 0x0c {
+			r.week = int(int16(u16(p[8:])))
+			r.work.Stamp = towTime(r.week, itow)
+			r.work.Valid |= VALID_DATE
+		}
+		r.work.Quality = ubxQuality(p[10], p[11]&1 == 1, p[11]&2 == 2)
+		r.work.FixType = ubxFixType(p[10])
+		r.work.Sats = p[47]
+		r.work.Valid |= VALID_SATS
+		if !r.dop {
+			r.work.Pdop = float32(u16(p[44:])) / 100
+			r.work.Hdop = r.work.Pdop
+		}
+
+	case UBX_NAV_DOP:
+		if len(p) < 18 {
+			return done
+		}
+		r.dop = true
+		r.work.Pdop = float32(u16(p[6:])) / 100
+		r.work.Vdop = float32(u16(p[10:])) / 100
+		r.work.Hdop = float32(u16(p[12:])) / 100
+		r.work.Valid |= VALID_DOP
+
+	default:
+		return done
+	}
+
+	if r.id > r.maxid {
+		r.maxid = r.id
+	}
+	// the last message of an epoch is learnt from the previous epoch
+	if r.id == r.last && !r.emitted {
+		r.emit()
+		r.emitted = true
+		done = true
+	}
+	return done
+}
+
+// UBXFrame returns a UBX message
+func UBXFrame(class, id byte, payload []byte) []byte {
+	b := make([]byte, 8+len(payload))
+	b[0] = UBX_SYNC1
+	b[1] = UBX_SYNC2
+	b[2] = class
+	b[3] = id
+	binary.LittleEndian.PutUint16(b[4:], uint16(len(payload)))
+	copy(b[6:], payload)
+	cka, ckb := byte(0), byte(0)
+	for _, c := range b[2 : 6+len(payload)] {
+		cka += c
+		ckb += cka
+	}
+	b[6+len(payload)] = cka
+	b[7+len(payload)] = ckb
+	return b
+}
+
+// UBXCfgPrt sets UART1 to baud, 8N1, accepting UBX and NMEA input; output
+// is UBX and / or NMEA
+func UBXCfgPrt(baud uint32, ubx, nmea bool) []byte {
+	p := make([]byte, 20)
+	p[0] = 1
+	binary.LittleEndian.PutUint32(p[4:], 0x08d0)
+	binary.LittleEndian.PutUint32(p[8:], baud)
+	binary.LittleEndian.PutUint16(p[12:], 3)
+	out := uint16(0)
+	if ubx {
+		out |= 1
+	}
+	if nmea {
+		out |= 2
+	}
+	binary.LittleEndian.PutUint16(p[14:], out)
+	return UBXFrame(UBX_CLASS_CFG, UBX_CFG_PRT, p)
+}
+
+// UBXCfgRate sets the measurement interval (ms)
+func UBXCfgRate(ms uint16) []byte {
+	p := make([]byte, 6)
+	binary.LittleEndian.PutUint16(p, ms)
+	binary.LittleEndian.PutUint16(p[2:], 1)
+	binary.LittleEndian.PutUint16(p[4:], 1)
+	return UBXFrame(UBX_CLASS_CFG, UBX_CFG_RATE, p)
+}
+
+// UBXCfgMsg sets the output rate (per navigation solution, 0 = off) of a
+// message on the current port
+func UBXCfgMsg(class, id, rate byte) []byte {
+	return UBXFrame(UBX_CLASS_CFG, UBX_CFG_MSG, []byte{class, id, rate})
+}
+
+// UBXCfgNav5 sets the dynamic model
+func UBXCfgNav5(model byte) []byte {
+	p := make([]byte, 36)
+	binary.LittleEndian.PutUint16(p, 1)
+	p[2] = model
+	return UBXFrame(UBX_CLASS_CFG, UBX_CFG_NAV5, p)
+}
+
+// UBXConfig returns the messages to switch a receiver to UBX output at rate
+// (Hz); pvt selects NAV-PVT (else POSLLH, VELNED and SOL), with NAV-DOP in
+// either case. The port setting (which may change the baud rate) is last.
+func UBXConfig(baud uint32, rate int, pvt bool, model byte) [][]byte {
+	if rate < 1 {
+		rate = 1
+	}
+	cfg := [][]byte{
+		UBXCfgRate(uint16(1000 / rate)),
+		UBXCfgNav5(model),
+		UBXCfgMsg(UBX_CLASS_NAV, UBX_NAV_DOP, 1),
+	}
+	on := byte(0)
+	if pvt {
+		on = 1
+	}
+	cfg = append(cfg,
+		UBXCfgMsg(UBX_CLASS_NAV, UBX_NAV_PVT, on),
+		UBXCfgMsg(UBX_CLASS_NAV, UBX_NAV_POSLLH, 1-on),
+		UBXCfgMsg(UBX_CLASS_NAV, UBX_NAV_VELNED, 1-on),
+		UBXCfgMsg(UBX_CLASS_NAV, UBX_NAV_SOL, 1-on),
+		UBXCfgPrt(baud, true, false))
+	return cfg
+}
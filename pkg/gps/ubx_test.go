@@ -0,0 +1,207 @@
+package gps
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// feed passes frames to p, returning the fixes completed
+func feed(p *UBXParser, frames ...[]byte) []Fix {
+	var fixes []Fix
+	for _, b := range frames {
+		for _, c := range b {
+			if p.Parse(c) {
+				fixes = append(fixes, p.Fix)
+			}
+		}
+	}
+	return fixes
+}
+
+func put16(b []byte, v int) {
+	binary.LittleEndian.PutUint16(b, uint16(v))
+}
+
+func put32(b []byte, v int) {
+	binary.LittleEndian.PutUint32(b, uint32(int32(v)))
+}
+
+// itow of 2026-10-14 12:30:00 UTC (GPS week 2440)
+const (
+	testWeek = 2440
+	testItow = (3*86400 + 12*3600 + 30*60 + 18) * 1000
+)
+
+func navPVT(itow int) []byte {
+	p := make([]byte, 92)
+	put32(p, itow)
+	put16(p[4:], 2026)
+	p[6], p[7], p[8], p[9], p[10] = 10, 17, 12, 30, 45
+	p[11] = 3 // validDate, validTime
+	put32(p[16:], 250000000)
+	p[20] = 3 // 3D
+	p[21] = 1 // gnssFixOK
+	p[23] = 12
+	put32(p[24:], -1000000) // -0.1
+	put32(p[28:], 515000000)
+	put32(p[36:], 45000) // hMSL, mm
+	put32(p[40:], 1500)
+	put32(p[44:], 2500)
+	put32(p[48:], 0)    // velN, mm/s
+	put32(p[52:], 2000) // velE
+	put32(p[56:], -100) // velD
+	put32(p[60:], 2000) // gSpeed
+	put32(p[64:], 9000000)
+	put32(p[68:], 300)
+	put16(p[76:], 150)
+	return UBXFrame(UBX_CLASS_NAV, UBX_NAV_PVT, p)
+}
+
+func TestUBXPVT(t *testing.T) {
+	fixes := feed(NewUBXParser(), navPVT(testItow))
+	if len(fixes) != 1 {
+		t.Fatalf("got %d fixes, want 1", len(fixes))
+	}
+	f := fixes[0]
+	want := time.Date(2026, 10, 17, 12, 30, 45, 250000000, time.UTC)
+	if !f.Stamp.Equal(want) {
+		t.Errorf("stamp %v, want %v", f.Stamp, want)
+	}
+	if f.Quality != 1 || f.FixType != 3 || f.Sats != 12 {
+		t.Errorf("quality %d fix type %d sats %d", f.Quality, f.FixType, f.Sats)
+	}
+	if f.Lat7 != 515000000 || f.Lon7 != -1000000 || f.Alt != 45 {
+		t.Errorf("position %d %d %.1f", f.Lat7, f.Lon7, f.Alt)
+	}
+	if f.VelE != 2 || f.VelD != -0.1 || f.Hdg != 90 || f.Spd < 3.88 || f.Spd > 3.89 {
+		t.Errorf("velocity E %.2f D %.2f speed %.2fkt hdg %.1f", f.VelE, f.VelD, f.Spd, f.Hdg)
+	}
+	if f.HAcc != 1.5 || f.VAcc != 2.5 || f.SAcc != 0.3 || f.Pdop != 1.5 || f.Hdop != 1.5 {
+		t.Errorf("accuracy %.1f %.1f %.1f dop %.1f %.1f", f.HAcc, f.VAcc, f.SAcc, f.Pdop, f.Hdop)
+	}
+	all := VALID_TIME | VALID_DATE | VALID_POS | VALID_ALT | VALID_SATS | VALID_VEL | VALID_NED | VALID_DOP | VALID_ACC
+	if f.Valid != uint16(all) {
+		t.Errorf("valid %x, want %x", f.Valid, all)
+	}
+
+	// no fix
+	b := navPVT(testItow + 1000)
+	b[6+20] = 0
+	b[6+21] = 0
+	fixes = feed(NewUBXParser(), UBXFrame(UBX_CLASS_NAV, UBX_NAV_PVT, b[6:6+92]))
+	if len(fixes) != 1 || fixes[0].Quality != 0 || fixes[0].Valid&VALID_POS != 0 {
+		t.Errorf("no fix: %+v", fixes)
+	}
+}
+
+// legacy returns an epoch of NAV-POSLLH, NAV-DOP, NAV-SOL and NAV-VELNED,
+// in the receiver's (id) order
+func legacy(itow int, flags byte) [][]byte {
+	pos := make([]byte, 28)
+	put32(pos, itow)
+	put32(pos[4:], -1000000)
+	put32(pos[8:], 515000000)
+	put32(pos[16:], 45000)
+	put32(pos[20:], 2000)
+	put32(pos[24:], 3000)
+
+	sol := make([]byte, 52)
+	put32(sol, itow)
+	put16(sol[8:], testWeek)
+	sol[10] = 3
+	sol[11] = flags
+	put16(sol[44:], 200)
+	sol[47] = 9
+
+	vel := make([]byte, 36)
+	put32(vel, itow)
+	put32(vel[4:], 150) // velN, cm/s
+	put32(vel[20:], 150)
+	put32(vel[24:], 0)
+	put32(vel[28:], 50)
+
+	dop := make([]byte, 18)
+	put32(dop, itow)
+	put16(dop[6:], 180)
+	put16(dop[10:], 120)
+	put16(dop[12:], 90)
+
+	return [][]byte{
+		UBXFrame(UBX_CLASS_NAV, UBX_NAV_POSLLH, pos),
+		UBXFrame(UBX_CLASS_NAV, UBX_NAV_DOP, dop),
+		UBXFrame(UBX_CLASS_NAV, UBX_NAV_SOL, sol),
+		UBXFrame(UBX_CLASS_NAV, UBX_NAV_VELNED, vel),
+	}
+}
+
+func TestUBXLegacy(t *testing.T) {
+	tests := []struct {
+		name  string
+		flags byte
+		stamp time.Time
+		date  bool
+	}{
+		{"week set", 0x0d, time.Date(2026, 10, 14, 12, 30, 0, 0, time.UTC), true},
+		{"week not set", 0x09, time.Date(0, 0, 0, 12, 30, 0, 0, time.UTC), false},
+		{"tow not set", 0x05, time.Date(0, 0, 0, 12, 30, 0, 0, time.UTC), false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p := NewUBXParser()
+			// the first epoch is emitted when the second starts; the
+			// second on its last message (NAV-VELNED)
+			fixes := feed(p, legacy(testItow, tc.flags)...)
+			fixes = append(fixes, feed(p, legacy(testItow+1000, tc.flags)...)...)
+			if len(fixes) != 2 {
+				t.Fatalf("got %d fixes, want 2", len(fixes))
+			}
+			f := fixes[0]
+			if !f.Stamp.Equal(tc.stamp) || (f.Valid&VALID_DATE != 0) != tc.date {
+				t.Errorf("stamp %v date %v, want %v %v", f.Stamp, f.Valid&VALID_DATE != 0, tc.stamp, tc.date)
+			}
+			if d := fixes[1].Stamp.Sub(f.Stamp); d != time.Second {
+				t.Errorf("epochs %v apart", d)
+			}
+			if f.Quality != 1 || f.FixType != 3 || f.Sats != 9 {
+				t.Errorf("quality %d fix type %d sats %d", f.Quality, f.FixType, f.Sats)
+			}
+			if f.Lat7 != 515000000 || f.Lon7 != -1000000 || f.Alt != 45 || f.HAcc != 2 || f.VAcc != 3 {
+				t.Errorf("position %d %d %.1f acc %.1f %.1f", f.Lat7, f.Lon7, f.Alt, f.HAcc, f.VAcc)
+			}
+			if f.VelN != 1.5 || f.Hdg != 0 || f.SAcc != 0.5 {
+				t.Errorf("velocity N %.2f hdg %.1f sacc %.2f", f.VelN, f.Hdg, f.SAcc)
+			}
+			// NAV-DOP rather than the SOL PDOP
+			if f.Pdop != 1.8 || f.Vdop != 1.2 || f.Hdop != 0.9 {
+				t.Errorf("dop %.1f %.1f %.1f", f.Pdop, f.Vdop, f.Hdop)
+			}
+			want := VALID_TIME | VALID_POS | VALID_ALT | VALID_SATS | VALID_VEL | VALID_NED | VALID_DOP | VALID_ACC
+			if tc.date {
+				want |= VALID_DATE
+			}
+			if f.Valid != uint16(want) {
+				t.Errorf("valid %x, want %x", f.Valid, want)
+			}
+		})
+	}
+}
+
+func TestUBXAck(t *testing.T) {
+	p := NewUBXParser()
+	feed(p, UBXFrame(UBX_CLASS_ACK, UBX_ACK_ACK, []byte{UBX_CLASS_CFG, UBX_CFG_RATE}))
+	if p.Acks != 1 || p.Ack != (UBXAck{Class: UBX_CLASS_CFG, Id: UBX_CFG_RATE, Ok: true}) {
+		t.Errorf("ack %d %+v", p.Acks, p.Ack)
+	}
+	feed(p, UBXFrame(UBX_CLASS_ACK, UBX_ACK_NAK, []byte{UBX_CLASS_CFG, UBX_CFG_PRT}))
+	if p.Acks != 2 || p.Ack != (UBXAck{Class: UBX_CLASS_CFG, Id: UBX_CFG_PRT, Ok: false}) {
+		t.Errorf("nak %d %+v", p.Acks, p.Ack)
+	}
+	// a corrupt frame is ignored
+	b := UBXFrame(UBX_CLASS_ACK, UBX_ACK_ACK, []byte{UBX_CLASS_CFG, UBX_CFG_MSG})
+	b[len(b)-1] ^= 0xff
+	feed(p, b)
+	if p.Acks != 2 || p.Frames != 2 {
+		t.Errorf("corrupt frame counted: acks %d frames %d", p.Acks, p.Frames)
+	}
+}
@@ -0,0 +1,143 @@
+package msp
+
+import (
+	"io"
+	"time"
+)
+
+// Client is the link independent side of a MSP connection: it encodes
+// requests in the selected protocol version, tracks replies and verifies
+// waypoints. Frames are written to w; now supplies the time for request
+// timeouts.
+type Client struct {
+	w       io.Writer
+	now     func() time.Time
+	vers    byte
+	detect  bool
+	probeV1 bool
+	verify  bool
+	*Tracker
+	WpVerifier
+}
+
+func NewClient(w io.Writer, now func() time.Time) *Client {
+	c := &Client{w: w, now: now, vers: MSP_V2}
+	c.Tracker = NewTracker(c.write)
+	return c
+}
+
+// SetVersion selects the MSP protocol version; MSP_AUTO alternates v2 and
+// v1 MSP_FC_VARIANT requests until the FC replies, then uses that version.
+func (c *Client) SetVersion(vers byte) {
+	c.vers = vers
+	c.detect = (vers == MSP_AUTO)
+	c.probeV1 = false
+}
+
+// Version returns the protocol version in use (MSP_AUTO while probing)
+func (c *Client) Version() byte {
+	return c.vers
+}
+
+// MSPCommand sends a request; the reply should be passed to Reply and
+// Poll called periodically to retry lost requests.
+func (c *Client) MSPCommand(cmd uint16, payload []byte) {
+	c.Request(cmd, payload, c.now())
+}
+
+// Reply completes the outstanding request matching msg
+func (c *Client) Reply(msg MSPMsg) (time.Duration, error) {
+	if c.detect && msg.Ok && msg.Dir != DIR_ERROR && msg.Cmd == MSP_FC_VARIANT {
+		c.vers = msg.Vers
+		c.detect = false
+	}
+	return c.Tracker.Reply(msg, c.now())
+}
+
+// Poll retries timed out requests, returning those that have failed
+func (c *Client) Poll() []uint16 {
+	failed := c.Tracker.Poll(c.now())
+	for _, cmd := range failed {
+		if cmd == MSP_WP {
+			c.Lost()
+		}
+	}
+	return failed
+}
+
+func (c *Client) write(cmd uint16, payload []byte) {
+	vers := c.vers
+	if c.detect {
+		vers = MSP_V2
+		if cmd == MSP_FC_VARIANT {
+			if c.probeV1 {
+				vers = MSP_V1
+			}
+			c.probeV1 = !c.probeV1
+		}
+	}
+	c.w.Write(Encode(vers, DIR_REQUEST, cmd, payload))
+}
+
+// Update_WP sends a waypoint; alt (cm) is relative to home, 0 keeps the
+// vehicle altitude.
+func (c *Client) Update_WP(wpno byte, lat, lon float32, alt int32, brg uint16) {
+	wp := Waypoint{
+		Number: wpno,
+		Action: wp_WAYPOINT,
+		Lat:    int32(lat * 1e7),
+		Lon:    int32(lon * 1e7),
+		Alt:    alt,
+		P1:     int16(brg),
+		Flag:   0xa5, // not checked, so 0 would do
+	}
+	c.SendWP(wp)
+}
+
+func (c *Client) SendWP(wp Waypoint) {
+	if c.verify {
+		c.Sent(wp)
+	}
+	c.MSPCommand(MSP_SET_WP, wp.Encode())
+}
+
+// Reset discards outstanding requests and unverified waypoints
+func (c *Client) Reset() {
+	c.Tracker.Reset()
+	c.WpVerifier.Reset()
+}
+
+// SetVerify enables MSP_WP read back of waypoints sent by SendWP (other
+// than WP_FOLLOW)
+func (c *Client) SetVerify(verify bool, retries int, tolerance float32) {
+	c.verify = verify
+	c.WpVerifier.Retries = retries
+	c.Tolerance = tolerance
+	c.WpVerifier.Reset()
+}
+
+// VerifyWP requests the read back of the next unverified waypoint; call
+// on MSP_SET_WP acknowledgement
+func (c *Client) VerifyWP() {
+	if c.Pending(MSP_WP) {
+		return
+	}
+	if wpno, ok := c.Next(); ok {
+		c.MSPCommand(MSP_WP, []byte{wpno})
+	}
+}
+
+// CheckWP handles a MSP_WP reply, resending the waypoint on mismatch
+func (c *Client) CheckWP(data []byte) (int, Waypoint, error) {
+	got, err := DecodeWaypoint(data)
+	if err != nil {
+		return WP_VERIFY_UNKNOWN, got, err
+	}
+	res, wp := c.Check(got)
+	if res == WP_VERIFY_RETRY {
+		c.MSPCommand(MSP_SET_WP, wp.Encode())
+	} else {
+		c.VerifyWP()
+	}
+	return res, wp, nil
+}
@@ -0,0 +1,347 @@
+// Package msp implements MSPv1 and MSPv2 framing and the INAV messages
+// used by the follower. Only the UART reader (uart.go) depends on TinyGo;
+// the codec and message types are shared with the host tools.
+package msp
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+type MSPMsg struct {
+	Len  uint16
+	Cmd  uint16
+	Ok   bool
+	Dir  byte
+	Vers byte
+	Data []byte
+}
+
+const (
+	MSP_FC_VARIANT  uint16 = 2
+	MSP_FC_VERSION  uint16 = 3
+	MSP2_INAV_MIXER uint16 = 0x2010
+	MSP_NAME        uint16 = 10
+	MSP_RAW_GPS     uint16 = 106
+	MSP_ALTITUDE    uint16 = 109
+	MSP_WP          uint16 = 118
+	MSP_SET_WP      uint16 = 209
+	MSP_NAV_STATUS  uint16 = 121
+	MSP_V2_FRAME    uint16 = 255
+)
+
+// Protocol versions; MSP_AUTO probes for the version the FC answers
+const (
+	MSP_AUTO byte = 0
+	MSP_V1   byte = 1
+	MSP_V2   byte = 2
+)
+
+// Largest payload accepted by the Decoder; a longer (corrupt) length
+// discards the frame rather than allocating and waiting for it
+const MSP_MAX_PAYLOAD = 512
+
+// Frame direction byte (third byte of the header)
+const (
+	DIR_REQUEST  byte = '<' // to the FC
+	DIR_RESPONSE byte = '>' // from the FC
+	DIR_ERROR    byte = '!' // NAK / unsupported command
+)
+
+const (
+	state_INIT = iota
+	state_MX
+	state_HEADER2
+	state_FLAGS
+	state_ID1
+	state_ID2
+	state_LEN1
+	state_LEN2
+	state_DATA
+	state_CHECKSUM
+	state_V1_LEN
+	state_V1_CMD
+	state_V1_JLEN1
+	state_V1_JLEN2
+	state_V1_DATA
+	state_V1_CHECKSUM
+)
+
+func crc8_dvb_s2(crc byte, a byte) byte {
+	crc ^= a
+	for i := 0; i < 8; i++ {
+		if (crc & 0x80) != 0 {
+			crc = (crc << 1) ^ 0xd5
+		} else {
+			crc = crc << 1
+		}
+	}
+	return crc
+}
+
+// Decoder is an incremental MSP frame parser, fed one byte at a time.
+// Both MSPv1 (including jumbo frames and MSPv2 tunnelled in MSPv1) and
+// MSPv2 are recognised; msg.Vers reports the framing used.
+type Decoder struct {
+	state int
+	crc   byte
+	count uint16
+	msg   MSPMsg
+}
+
+func NewDecoder() *Decoder {
+	return &Decoder{}
+}
+
+// Parse consumes a byte; when a frame completes it is returned with true.
+// msg.Ok is false for a checksum failure; an error ('!') frame has Dir
+// DIR_ERROR.
+func (d *Decoder) Parse(c byte) (MSPMsg, bool) {
+	switch d.state {
+	case state_INIT:
+		if c == '$' {
+			d.state = state_MX
+			d.msg = MSPMsg{}
+		}
+
+	case state_MX:
+		if c == 'X' {
+			d.msg.Vers = MSP_V2
+			d.state = state_HEADER2
+		} else if c == 'M' {
+			d.msg.Vers = MSP_V1
+			d.state = state_HEADER2
+		} else if c != '$' {
+			d.state = state_INIT
+		}
+
+	case state_HEADER2:
+		if c == DIR_REQUEST || c == DIR_RESPONSE || c == DIR_ERROR {
+			d.msg.Dir = c
+			if d.msg.Vers == MSP_V1 {
+				d.state = state_V1_LEN
+			} else {
+				d.state = state_FLAGS
+			}
+		} else {
+			d.state = state_INIT
+		}
+
+	case state_FLAGS:
+		d.crc = crc8_dvb_s2(0, c)
+		d.state = state_ID1
+
+	case state_ID1:
+		d.crc = crc8_dvb_s2(d.crc, c)
+		d.msg.Cmd = uint16(c)
+		d.state = state_ID2
+
+	case state_ID2:
+		d.crc = crc8_dvb_s2(d.crc, c)
+		d.msg.Cmd |= uint16(c) << 8
+		d.state = state_LEN1
+
+	case state_LEN1:
+		d.crc = crc8_dvb_s2(d.crc, c)
+		d.msg.Len = uint16(c)
+		d.state = state_LEN2
+
+	case state_LEN2:
+		d.count = 0
+		d.crc = crc8_dvb_s2(d.crc, c)
+		d.msg.Len |= uint16(c) << 8
+		if d.msg.Len > MSP_MAX_PAYLOAD {
+			d.state = state_INIT
+		} else if d.msg.Len > 0 {
+			d.state = state_DATA
+			d.msg.Data = make([]byte, d.msg.Len)
+		} else {
+			d.state = state_CHECKSUM
+		}
+
+	case state_DATA:
+		d.crc = crc8_dvb_s2(d.crc, c)
+		d.msg.Data[d.count] = c
+		d.count++
+		if d.count == d.msg.Len {
+			d.state = state_CHECKSUM
+		}
+
+	case state_CHECKSUM:
+		d.state = state_INIT
+		d.msg.Ok = (d.crc == c)
+		return d.msg, true
+
+	case state_V1_LEN:
+		d.crc = c
+		d.msg.Len = uint16(c)
+		d.state = state_V1_CMD
+
+	case state_V1_CMD:
+		d.crc ^= c
+		d.msg.Cmd = uint16(c)
+		if d.msg.Len == 255 {
+			d.state = state_V1_JLEN1
+		} else {
+			d.v1data()
+		}
+
+	case state_V1_JLEN1:
+		d.crc ^= c
+		d.msg.Len = uint16(c)
+		d.state = state_V1_JLEN2
+
+	case state_V1_JLEN2:
+		d.crc ^= c
+		d.msg.Len |= uint16(c) << 8
+		d.v1data()
+
+	case state_V1_DATA:
+		d.crc ^= c
+		d.msg.Data[d.count] = c
+		d.count++
+		if d.count == d.msg.Len {
+			d.state = state_V1_CHECKSUM
+		}
+
+	case state_V1_CHECKSUM:
+		d.state = state_INIT
+		d.msg.Ok = (d.crc == c)
+		if d.crc == c && d.msg.Cmd == MSP_V2_FRAME {
+			d.untunnel()
+		}
+		return d.msg, true
+	}
+	return MSPMsg{}, false
+}
+
+func (d *Decoder) v1data() {
+	d.count = 0
+	if d.msg.Len > MSP_MAX_PAYLOAD {
+		d.state = state_INIT
+	} else if d.msg.Len > 0 {
+		d.msg.Data = make([]byte, d.msg.Len)
+		d.state = state_V1_DATA
+	} else {
+		d.state = state_V1_CHECKSUM
+	}
+}
+
+// untunnel replaces a MSPv1 MSP_V2_FRAME with the MSPv2 message it carries
+func (d *Decoder) untunnel() {
+	b := d.msg.Data
+	if len(b) < 6 {
+		d.msg.Ok = false
+		return
+	}
+	plen := binary.LittleEndian.Uint16(b[3:5])
+	if int(plen)+6 > len(b) {
+		d.msg.Ok = false
+		return
+	}
+	crc := byte(0)
+	for _, c := range b[:5+plen] {
+		crc = crc8_dvb_s2(crc, c)
+	}
+	if crc != b[5+plen] {
+		d.msg.Ok = false
+	}
+	d.msg.Cmd = binary.LittleEndian.Uint16(b[1:3])
+	d.msg.Len = plen
+	d.msg.Data = b[5 : 5+plen]
+}
+
+// StreamDecoder reads MSP frames from an io.Reader (serial port, socket etc.)
+type StreamDecoder struct {
+	r   io.Reader
+	buf []byte
+	n   int
+	i   int
+	Decoder
+}
+
+func NewStreamDecoder(r io.Reader) *StreamDecoder {
+	return &StreamDecoder{r: r, buf: make([]byte, 128)}
+}
+
+// Next blocks until a complete frame has been read, or the reader fails
+func (s *StreamDecoder) Next() (MSPMsg, error) {
+	for {
+		for ; s.i < s.n; s.i++ {
+			if msg, done := s.Parse(s.buf[s.i]); done {
+				s.i++
+				return msg, nil
+			}
+		}
+		n, err := s.r.Read(s.buf)
+		if err != nil {
+			return MSPMsg{}, err
+		}
+		if n == 0 {
+			return MSPMsg{}, io.EOF
+		}
+		s.n = n
+		s.i = 0
+	}
+}
+
+// EncodeV2 returns a MSPv2 frame; dir is DIR_REQUEST for messages to the FC
+func EncodeV2(dir byte, cmd uint16, payload []byte) []byte {
+	paylen := uint16(len(payload))
+	buf := make([]byte, 9+paylen)
+	buf[0] = '$'
+	buf[1] = 'X'
+	buf[2] = dir
+	buf[3] = 0 // flags
+	binary.LittleEndian.PutUint16(buf[4:6], cmd)
+	binary.LittleEndian.PutUint16(buf[6:8], paylen)
+	if paylen > 0 {
+		copy(buf[8:], payload)
+	}
+	crc := byte(0)
+	for _, b := range buf[3 : paylen+8] {
+		crc = crc8_dvb_s2(crc, b)
+	}
+	buf[8+paylen] = crc
+	return buf
+}
+
+// EncodeV1 returns a MSPv1 frame. Payloads of 255 bytes or more use a
+// jumbo frame and commands above 255 are tunnelled as MSPv2.
+func EncodeV1(dir byte, cmd uint16, payload []byte) []byte {
+	if cmd > MSP_V2_FRAME {
+		v2 := EncodeV2(dir, cmd, payload)
+		return EncodeV1(dir, MSP_V2_FRAME, v2[3:])
+	}
+	paylen := len(payload)
+	hlen := 5
+	if paylen >= 255 {
+		hlen = 7
+	}
+	buf := make([]byte, hlen+paylen+1)
+	buf[0] = '$'
+	buf[1] = 'M'
+	buf[2] = dir
+	buf[4] = byte(cmd)
+	if paylen >= 255 {
+		buf[3] = 255
+		binary.LittleEndian.PutUint16(buf[5:7], uint16(paylen))
+	} else {
+		buf[3] = byte(paylen)
+	}
+	copy(buf[hlen:], payload)
+	crc := byte(0)
+	for _, b := range buf[3 : hlen+paylen] {
+		crc ^= b
+	}
+	buf[hlen+paylen] = crc
+	return buf
+}
+
+// Encode returns a frame in the requested protocol version (MSPv2 unless MSP_V1)
+func Encode(vers byte, dir byte, cmd uint16, payload []byte) []byte {
+	if vers == MSP_V1 {
+		return EncodeV1(dir, cmd, payload)
+	}
+	return EncodeV2(dir, cmd, payload)
+}
@@ -0,0 +1,140 @@
+package msp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func seq(n int) []byte {
+	b := make([]byte, n)
+	for j := range b {
+		b[j] = byte(j * 7)
+	}
+	return b
+}
+
+// decode feeds b to a new Decoder, returning the frames completed
+func decode(b []byte) []MSPMsg {
+	d := NewDecoder()
+	var msgs []MSPMsg
+	for _, c := range b {
+		if m, ok := d.Parse(c); ok {
+			msgs = append(msgs, m)
+		}
+	}
+	return msgs
+}
+
+func TestDecoder(t *testing.T) {
+	corrupt := func(b []byte) []byte {
+		b[len(b)-1] ^= 0x5a
+		return b
+	}
+	errframe := func(b []byte) []byte {
+		b[2] = DIR_ERROR
+		return b
+	}
+	tests := []struct {
+		name  string
+		frame []byte
+		cmd   uint16
+		vers  byte
+		dir   byte
+		ok    bool
+		data  []byte
+	}{
+		{"v1", EncodeV1(DIR_RESPONSE, MSP_RAW_GPS, seq(18)), MSP_RAW_GPS, MSP_V1, DIR_RESPONSE, true, seq(18)},
+		{"v1 empty", EncodeV1(DIR_REQUEST, MSP_NAME, nil), MSP_NAME, MSP_V1, DIR_REQUEST, true, nil},
+		{"v1 jumbo", EncodeV1(DIR_RESPONSE, MSP_NAME, seq(300)), MSP_NAME, MSP_V1, DIR_RESPONSE, true, seq(300)},
+		{"v2", EncodeV2(DIR_RESPONSE, MSP2_INAV_MIXER, seq(9)), MSP2_INAV_MIXER, MSP_V2, DIR_RESPONSE, true, seq(9)},
+		{"v2 over v1", EncodeV1(DIR_RESPONSE, MSP2_INAV_MIXER, seq(9)), MSP2_INAV_MIXER, MSP_V1, DIR_RESPONSE, true, seq(9)},
+		{"v1 bad crc", corrupt(EncodeV1(DIR_RESPONSE, MSP_RAW_GPS, seq(18))), MSP_RAW_GPS, MSP_V1, DIR_RESPONSE, false, seq(18)},
+		{"v2 bad crc", corrupt(EncodeV2(DIR_RESPONSE, MSP_RAW_GPS, seq(18))), MSP_RAW_GPS, MSP_V2, DIR_RESPONSE, false, seq(18)},
+		{"v1 error", errframe(EncodeV1(DIR_RESPONSE, MSP_SET_WP, nil)), MSP_SET_WP, MSP_V1, DIR_ERROR, true, nil},
+		{"v2 error", errframe(EncodeV2(DIR_RESPONSE, MSP2_INAV_MIXER, nil)), MSP2_INAV_MIXER, MSP_V2, DIR_ERROR, true, nil},
+		{"v2 error bad crc", corrupt(errframe(EncodeV2(DIR_RESPONSE, MSP2_INAV_MIXER, nil))), MSP2_INAV_MIXER, MSP_V2, DIR_ERROR, false, nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			msgs := decode(tc.frame)
+			if len(msgs) != 1 {
+				t.Fatalf("got %d frames, want 1", len(msgs))
+			}
+			m := msgs[0]
+			if m.Cmd != tc.cmd || m.Vers != tc.vers || m.Dir != tc.dir || m.Ok != tc.ok {
+				t.Errorf("got cmd %d vers %d dir %c ok %v, want %d %d %c %v",
+					m.Cmd, m.Vers, m.Dir, m.Ok, tc.cmd, tc.vers, tc.dir, tc.ok)
+			}
+			if int(m.Len) != len(tc.data) || !bytes.Equal(m.Data, tc.data) {
+				t.Errorf("got payload %d % x, want %d % x", m.Len, m.Data, len(tc.data), tc.data)
+			}
+		})
+	}
+}
+
+func cat(bs ...[]byte) []byte {
+	var b []byte
+	for _, x := range bs {
+		b = append(b, x...)
+	}
+	return b
+}
+
+// after garbage, the decoder recovers (a truncated frame may consume the
+// next frame, so valid frames are repeated)
+func TestDecoderResync(t *testing.T) {
+	v1 := EncodeV1(DIR_RESPONSE, MSP_NAV_STATUS, seq(7))
+	v2 := EncodeV2(DIR_RESPONSE, MSP_RAW_GPS, seq(18))
+	tests := []struct {
+		name   string
+		prefix []byte
+	}{
+		{"garbage", []byte("junk$$M$X\x00\xff")},
+		{"truncated", v2[:7]},
+		{"v1 oversize", []byte{'$', 'M', '>', 255, 10, 0xff, 0xff}},
+		{"v2 oversize", []byte{'$', 'X', '>', 0, 1, 0, 0xff, 0xff}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			msgs := decode(cat(tc.prefix, v1, v2, v1, v2))
+			var good []uint16
+			for _, m := range msgs {
+				if m.Ok {
+					good = append(good, m.Cmd)
+				}
+			}
+			if len(good) < 2 || good[len(good)-2] != MSP_NAV_STATUS || good[len(good)-1] != MSP_RAW_GPS {
+				t.Errorf("good frames %v, want ... %d %d", good, MSP_NAV_STATUS, MSP_RAW_GPS)
+			}
+		})
+	}
+}
+
+func crc(b []byte) byte {
+	c := byte(0)
+	for _, x := range b {
+		c = crc8_dvb_s2(c, x)
+	}
+	return c
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name string
+		got  []byte
+		want []byte
+	}{
+		{"v1", EncodeV1(DIR_REQUEST, MSP_NAME, nil), []byte{'$', 'M', '<', 0, 10, 10}},
+		{"v1 payload", EncodeV1(DIR_REQUEST, MSP_SET_WP, []byte{1, 2}), []byte{'$', 'M', '<', 2, 209, 1, 2, 2 ^ 209 ^ 1 ^ 2}},
+		{"v2", EncodeV2(DIR_REQUEST, MSP2_INAV_MIXER, nil), []byte{'$', 'X', '<', 0, 0x10, 0x20, 0, 0, crc([]byte{0, 0x10, 0x20, 0, 0})}},
+		{"Encode v1", Encode(MSP_V1, DIR_REQUEST, MSP_NAME, nil), EncodeV1(DIR_REQUEST, MSP_NAME, nil)},
+		{"Encode v2", Encode(MSP_V2, DIR_REQUEST, MSP_NAME, nil), EncodeV2(DIR_REQUEST, MSP_NAME, nil)},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if !bytes.Equal(tc.got, tc.want) {
+				t.Errorf("got % x, want % x", tc.got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package msp
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+)
+
+var ErrShort = errors.New("Short MSP payload")
+
+// INAV platform types (MSP2_INAV_MIXER)
+const (
+	PLATFORM_MULTIROTOR = 0
+	PLATFORM_AIRPLANE   = 1
+	PLATFORM_HELICOPTER = 2
+	PLATFORM_TRICOPTER  = 3
+	PLATFORM_ROVER      = 4
+	PLATFORM_BOAT       = 5
+)
+
+// INAV GPS navigation modes (MSP_NAV_STATUS)
+const (
+	NAV_MODE_NONE = 0
+	NAV_MODE_HOLD = 1
+	NAV_MODE_RTH  = 2
+	NAV_MODE_NAV  = 3
+)
+
+const (
+	wp_WAYPOINT = 1
+)
+
+func DecodeFCVariant(b []byte) (string, error) {
+	if len(b) < 4 {
+		return "", ErrShort
+	}
+	return string(b[0:4]), nil
+}
+
+type FCVersion struct {
+	Major uint8
+	Minor uint8
+	Patch uint8
+}
+
+func DecodeFCVersion(b []byte) (FCVersion, error) {
+	if len(b) < 3 {
+		return FCVersion{}, ErrShort
+	}
+	return FCVersion{b[0], b[1], b[2]}, nil
+}
+
+func (v FCVersion) Encode() []byte {
+	return []byte{v.Major, v.Minor, v.Patch}
+}
+
+func (v FCVersion) String() string {
+	return strconv.Itoa(int(v.Major)) + "." + strconv.Itoa(int(v.Minor)) + "." + strconv.Itoa(int(v.Patch))
+}
+
+func DecodeName(b []byte) (string, error) {
+	return string(b), nil
+}
+
+type InavMixer struct {
+	MotorDirInverted uint8
+	MotorStopOnLow   uint8
+	PlatformType     uint8
+	HasFlaps         uint8
+	AppliedPreset    int16
+	MaxMotors        uint8
+	MaxServos        uint8
+}
+
+// DecodeInavMixer requires the platform type and flaps fields; the preset
+// and motor / servo counts are only present in newer firmware.
+func DecodeInavMixer(b []byte) (InavMixer, error) {
+	if len(b) < 5 {
+		return InavMixer{}, ErrShort
+	}
+	mx := InavMixer{MotorDirInverted: b[0], MotorStopOnLow: b[2], PlatformType: b[3], HasFlaps: b[4]}
+	if len(b) >= 9 {
+		mx.AppliedPreset = int16(binary.LittleEndian.Uint16(b[5:7]))
+		mx.MaxMotors = b[7]
+		mx.MaxServos = b[8]
+	}
+	return mx, nil
+}
+
+func (mx InavMixer) Encode() []byte {
+	buf := make([]byte, 9)
+	buf[0] = mx.MotorDirInverted
+	buf[2] = mx.MotorStopOnLow
+	buf[3] = mx.PlatformType
+	buf[4] = mx.HasFlaps
+	binary.LittleEndian.PutUint16(buf[5:7], uint16(mx.AppliedPreset))
+	buf[7] = mx.MaxMotors
+	buf[8] = mx.MaxServos
+	return buf
+}
+
+type RawGPS struct {
+	FixType uint8
+	NumSat  uint8
+	Lat     int32  // deg * 1e7
+	Lon     int32  // deg * 1e7
+	Alt     int16  // m
+	Speed   uint16 // cm/s
+	Cog     uint16 // deg * 10
+	Hdop    uint16 // * 100, 999 if not reported
+}
+
+func DecodeRawGPS(b []byte) (RawGPS, error) {
+	if len(b) < 16 {
+		return RawGPS{}, ErrShort
+	}
+	g := RawGPS{
+		FixType: b[0],
+		NumSat:  b[1],
+		Lat:     int32(binary.LittleEndian.Uint32(b[2:6])),
+		Lon:     int32(binary.LittleEndian.Uint32(b[6:10])),
+		Alt:     int16(binary.LittleEndian.Uint16(b[10:12])),
+		Speed:   binary.LittleEndian.Uint16(b[12:14]),
+		Cog:     binary.LittleEndian.Uint16(b[14:16]),
+		Hdop:    999,
+	}
+	if len(b) >= 18 {
+		g.Hdop = binary.LittleEndian.Uint16(b[16:18])
+	}
+	return g, nil
+}
+
+func (g RawGPS) Encode() []byte {
+	buf := make([]byte, 18)
+	buf[0] = g.FixType
+	buf[1] = g.NumSat
+	binary.LittleEndian.PutUint32(buf[2:6], uint32(g.Lat))
+	binary.LittleEndian.PutUint32(buf[6:10], uint32(g.Lon))
+	binary.LittleEndian.PutUint16(buf[10:12], uint16(g.Alt))
+	binary.LittleEndian.PutUint16(buf[12:14], g.Speed)
+	binary.LittleEndian.PutUint16(buf[14:16], g.Cog)
+	binary.LittleEndian.PutUint16(buf[16:18], g.Hdop)
+	return buf
+}
+
+type NavStatus struct {
+	Mode          uint8
+	State         uint8
+	WpAction      uint8
+	WpNumber      uint8
+	Error         uint8
+	HeadingTarget int16
+}
+
+func DecodeNavStatus(b []byte) (NavStatus, error) {
+	if len(b) < 7 {
+		return NavStatus{}, ErrShort
+	}
+	return NavStatus{
+		Mode:          b[0],
+		State:         b[1],
+		WpAction:      b[2],
+		WpNumber:      b[3],
+		Error:         b[4],
+		HeadingTarget: int16(binary.LittleEndian.Uint16(b[5:7])),
+	}, nil
+}
+
+func (n NavStatus) Encode() []byte {
+	buf := make([]byte, 7)
+	buf[0] = n.Mode
+	buf[1] = n.State
+	buf[2] = n.WpAction
+	buf[3] = n.WpNumber
+	buf[4] = n.Error
+	binary.LittleEndian.PutUint16(buf[5:7], uint16(n.HeadingTarget))
+	return buf
+}
+
+// Altitude is the reply to MSP_ALTITUDE, the estimated altitude relative
+// to home
+type Altitude struct {
+	Alt   int32 // cm
+	Vario int16 // cm/s
+}
+
+func DecodeAltitude(b []byte) (Altitude, error) {
+	if len(b) < 6 {
+		return Altitude{}, ErrShort
+	}
+	return Altitude{
+		Alt:   int32(binary.LittleEndian.Uint32(b[0:4])),
+		Vario: int16(binary.LittleEndian.Uint16(b[4:6])),
+	}, nil
+}
+
+func (a Altitude) Encode() []byte {
+	buf := make([]byte, 6)
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(a.Alt))
+	binary.LittleEndian.PutUint16(buf[4:6], uint16(a.Vario))
+	return buf
+}
+
+// Waypoint is the payload of MSP_SET_WP (and the reply to MSP_WP)
+type Waypoint struct {
+	Number uint8
+	Action uint8
+	Lat    int32 // deg * 1e7
+	Lon    int32 // deg * 1e7
+	Alt    int32 // cm
+	P1     int16
+	P2     int16
+	P3     int16
+	Flag   uint8
+}
+
+func DecodeWaypoint(b []byte) (Waypoint, error) {
+	if len(b) < 21 {
+		return Waypoint{}, ErrShort
+	}
+	return Waypoint{
+		Number: b[0],
+		Action: b[1],
+		Lat:    int32(binary.LittleEndian.Uint32(b[2:6])),
+		Lon:    int32(binary.LittleEndian.Uint32(b[6:10])),
+		Alt:    int32(binary.LittleEndian.Uint32(b[10:14])),
+		P1:     int16(binary.LittleEndian.Uint16(b[14:16])),
+		P2:     int16(binary.LittleEndian.Uint16(b[16:18])),
+		P3:     int16(binary.LittleEndian.Uint16(b[18:20])),
+		Flag:   b[20],
+	}, nil
+}
+
+func (w Waypoint) Encode() []byte {
+	buf := make([]byte, 21)
+	buf[0] = w.Number
+	buf[1] = w.Action
+	binary.LittleEndian.PutUint32(buf[2:6], uint32(w.Lat))
+	binary.LittleEndian.PutUint32(buf[6:10], uint32(w.Lon))
+	binary.LittleEndian.PutUint32(buf[10:14], uint32(w.Alt))
+	binary.LittleEndian.PutUint16(buf[14:16], uint16(w.P1))
+	binary.LittleEndian.PutUint16(buf[16:18], uint16(w.P2))
+	binary.LittleEndian.PutUint16(buf[18:20], uint16(w.P3))
+	buf[20] = w.Flag
+	return buf
+}
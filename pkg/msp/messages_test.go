@@ -0,0 +1,113 @@
+package msp
+
+import (
+	"testing"
+)
+
+func TestRawGPS(t *testing.T) {
+	g := RawGPS{FixType: 2, NumSat: 14, Lat: 501234567, Lon: -12345678, Alt: -12, Speed: 1234, Cog: 3599, Hdop: 87}
+	tests := []struct {
+		name string
+		b    []byte
+		want RawGPS
+	}{
+		{"with hdop", g.Encode(), g},
+		{"without hdop", g.Encode()[:16], RawGPS{FixType: 2, NumSat: 14, Lat: 501234567, Lon: -12345678, Alt: -12, Speed: 1234, Cog: 3599, Hdop: 999}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := DecodeRawGPS(tc.b)
+			if err != nil || got != tc.want {
+				t.Errorf("got %+v %v, want %+v", got, err, tc.want)
+			}
+		})
+	}
+}
+
+func TestNavStatus(t *testing.T) {
+	for _, n := range []NavStatus{
+		{},
+		{Mode: NAV_MODE_HOLD, State: 4, WpAction: 1, WpNumber: 255, Error: 0, HeadingTarget: -90},
+		{Mode: NAV_MODE_RTH, State: 8, Error: 3, HeadingTarget: 359},
+	} {
+		got, err := DecodeNavStatus(n.Encode())
+		if err != nil || got != n {
+			t.Errorf("got %+v %v, want %+v", got, err, n)
+		}
+	}
+}
+
+func TestAltitude(t *testing.T) {
+	for _, a := range []Altitude{{}, {Alt: 12345, Vario: -50}, {Alt: -300, Vario: 120}} {
+		got, err := DecodeAltitude(a.Encode())
+		if err != nil || got != a {
+			t.Errorf("got %+v %v, want %+v", got, err, a)
+		}
+	}
+}
+
+func TestInavMixer(t *testing.T) {
+	mx := InavMixer{MotorDirInverted: 1, MotorStopOnLow: 1, PlatformType: PLATFORM_TRICOPTER, HasFlaps: 0, AppliedPreset: -1, MaxMotors: 8, MaxServos: 16}
+	tests := []struct {
+		name string
+		b    []byte
+		want InavMixer
+	}{
+		{"long", mx.Encode(), mx},
+		{"short", mx.Encode()[:5], InavMixer{MotorDirInverted: 1, MotorStopOnLow: 1, PlatformType: PLATFORM_TRICOPTER}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := DecodeInavMixer(tc.b)
+			if err != nil || got != tc.want {
+				t.Errorf("got %+v %v, want %+v", got, err, tc.want)
+			}
+		})
+	}
+}
+
+func TestWaypoint(t *testing.T) {
+	for _, w := range []Waypoint{
+		{Number: 255, Action: wp_WAYPOINT, Lat: 501234567, Lon: -12345678, Alt: 2500, P1: 180, Flag: 0xa5},
+		{Number: 1, Action: wp_WAYPOINT, Lat: -339000000, Lon: 1512000000, Alt: -300, P1: -1, P2: 2, P3: 1},
+	} {
+		got, err := DecodeWaypoint(w.Encode())
+		if err != nil || got != w {
+			t.Errorf("got %+v %v, want %+v", got, err, w)
+		}
+	}
+}
+
+func TestFCVersion(t *testing.T) {
+	v := FCVersion{7, 1, 2}
+	got, err := DecodeFCVersion(v.Encode())
+	if err != nil || got != v || got.String() != "7.1.2" {
+		t.Errorf("got %v %v, want %v", got, err, v)
+	}
+}
+
+func TestShort(t *testing.T) {
+	decoders := []struct {
+		name string
+		n    int
+		dec  func([]byte) error
+	}{
+		{"FCVariant", 4, func(b []byte) error { _, err := DecodeFCVariant(b); return err }},
+		{"FCVersion", 3, func(b []byte) error { _, err := DecodeFCVersion(b); return err }},
+		{"InavMixer", 5, func(b []byte) error { _, err := DecodeInavMixer(b); return err }},
+		{"RawGPS", 16, func(b []byte) error { _, err := DecodeRawGPS(b); return err }},
+		{"Altitude", 6, func(b []byte) error { _, err := DecodeAltitude(b); return err }},
+		{"NavStatus", 7, func(b []byte) error { _, err := DecodeNavStatus(b); return err }},
+		{"Waypoint", 21, func(b []byte) error { _, err := DecodeWaypoint(b); return err }},
+	}
+	for _, d := range decoders {
+		t.Run(d.name, func(t *testing.T) {
+			if err := d.dec(make([]byte, d.n-1)); err != ErrShort {
+				t.Errorf("%d bytes: got %v, want ErrShort", d.n-1, err)
+			}
+			if err := d.dec(make([]byte, d.n)); err != nil {
+				t.Errorf("%d bytes: got %v", d.n, err)
+			}
+		})
+	}
+}
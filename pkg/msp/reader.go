@@ -0,0 +1,46 @@
+package msp
+
+// Transport is a MSP link (UART, serial device, socket, PTY ...)
+type Transport interface {
+	Read(b []byte) (int, error)
+	Write(b []byte) (int, error)
+	Close() error
+}
+
+// BaudSetter is implemented by transports with a baud rate
+type BaudSetter interface {
+	SetBaud(baud uint32) error
+}
+
+// MSPReader passes MSP messages received from the FC to mchan; it is the
+// io.Writer for a Client.
+type MSPReader struct {
+	Transport
+	mchan chan MSPMsg
+}
+
+func NewMSPReader(t Transport, mchan chan MSPMsg) *MSPReader {
+	return &MSPReader{Transport: t, mchan: mchan}
+}
+
+// SetBaud sets the baud rate, if the transport has one
+func (m *MSPReader) SetBaud(baud uint32) error {
+	if b, ok := m.Transport.(BaudSetter); ok {
+		return b.SetBaud(baud)
+	}
+	return nil
+}
+
+// Reader runs until the transport fails, returning the error
+func (m *MSPReader) Reader() error {
+	sd := NewStreamDecoder(m.Transport)
+	for {
+		msg, err := sd.Next()
+		if err != nil {
+			return err
+		}
+		if msg.Dir != DIR_REQUEST {
+			m.mchan <- msg
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package msp
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrNak      = errors.New("MSP NAK")
+	ErrChecksum = errors.New("MSP checksum")
+)
+
+const (
+	DEFAULT_RETRIES = 3
+	DEFAULT_TIMEOUT = 500 * time.Millisecond
+)
+
+type request struct {
+	cmd     uint16
+	wpno    int
+	payload []byte
+	sent    time.Time
+	tries   int
+}
+
+// Tracker correlates MSP requests with their replies by command id (and, for
+// MSP_SET_WP and MSP_WP, waypoint number). At most one request per command
+// and waypoint is outstanding; a request that is not answered
+// within its timeout is resent until the retry count is exhausted.
+// The caller supplies the time, so the tracker may be driven from any clock.
+type Tracker struct {
+	send     func(cmd uint16, payload []byte)
+	pending  []request
+	retries  int
+	timeout  time.Duration
+	timeouts map[uint16]time.Duration
+	rtt      time.Duration
+	srtt     time.Duration
+}
+
+func NewTracker(send func(cmd uint16, payload []byte)) *Tracker {
+	return &Tracker{send: send, retries: DEFAULT_RETRIES, timeout: DEFAULT_TIMEOUT,
+		timeouts: make(map[uint16]time.Duration)}
+}
+
+func (t *Tracker) SetRetries(n int) {
+	t.retries = n
+}
+
+// SetTimeout sets the default reply timeout
+func (t *Tracker) SetTimeout(d time.Duration) {
+	t.timeout = d
+}
+
+// SetCmdTimeout overrides the reply timeout for a single command; 0
+// restores the default
+func (t *Tracker) SetCmdTimeout(cmd uint16, d time.Duration) {
+	if d <= 0 {
+		delete(t.timeouts, cmd)
+		return
+	}
+	t.timeouts[cmd] = d
+}
+
+func (t *Tracker) cmdTimeout(cmd uint16) time.Duration {
+	if d, ok := t.timeouts[cmd]; ok {
+		return d
+	}
+	return t.timeout
+}
+
+// wpNumber returns the waypoint number of a MSP_SET_WP or MSP_WP request,
+// else -1
+func wpNumber(cmd uint16, payload []byte) int {
+	if (cmd == MSP_SET_WP || cmd == MSP_WP) && len(payload) > 0 {
+		return int(payload[0])
+	}
+	return -1
+}
+
+// find returns the oldest request for cmd, and wpno unless wpno is -1
+func (t *Tracker) find(cmd uint16, wpno int) int {
+	for i := range t.pending {
+		if t.pending[i].cmd == cmd && (wpno < 0 || t.pending[i].wpno == wpno) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (t *Tracker) remove(i int) {
+	t.pending = append(t.pending[:i], t.pending[i+1:]...)
+}
+
+// Request sends a command and records it as outstanding, replacing any
+// earlier unanswered request for the same command (and waypoint).
+func (t *Tracker) Request(cmd uint16, payload []byte, now time.Time) {
+	r := request{cmd: cmd, wpno: wpNumber(cmd, payload), payload: payload, sent: now}
+	if i := t.find(cmd, r.wpno); i >= 0 {
+		t.pending[i] = r
+	} else {
+		t.pending = append(t.pending, r)
+	}
+	t.send(cmd, payload)
+}
+
+// Pending reports whether a request for cmd is awaiting a reply
+func (t *Tracker) Pending(cmd uint16) bool {
+	return t.find(cmd, -1) >= 0
+}
+
+// Reply matches a received message against the outstanding requests,
+// returning the round trip time. A MSP_WP reply is matched by waypoint
+// number; the (empty) MSP_SET_WP acknowledgement completes the oldest
+// MSP_SET_WP request. A NAK completes the request with ErrNak; a checksum
+// failure leaves it outstanding so it will be retried.
+func (t *Tracker) Reply(msg MSPMsg, now time.Time) (time.Duration, error) {
+	if !msg.Ok {
+		return 0, ErrChecksum
+	}
+	rtt := time.Duration(0)
+	wpno := -1
+	if msg.Cmd == MSP_WP && msg.Dir != DIR_ERROR {
+		wpno = wpNumber(msg.Cmd, msg.Data)
+	}
+	if i := t.find(msg.Cmd, wpno); i >= 0 {
+		rtt = now.Sub(t.pending[i].sent)
+		t.remove(i)
+		t.rtt = rtt
+		if t.srtt == 0 {
+			t.srtt = rtt
+		} else {
+			t.srtt = (7*t.srtt + rtt) / 8
+		}
+	}
+	if msg.Dir == DIR_ERROR {
+		return rtt, ErrNak
+	}
+	return rtt, nil
+}
+
+// Poll resends timed out requests and returns the commands that have
+// exhausted their retries; these are no longer outstanding.
+func (t *Tracker) Poll(now time.Time) []uint16 {
+	var failed []uint16
+	for i := 0; i < len(t.pending); {
+		r := &t.pending[i]
+		if now.Sub(r.sent) < t.cmdTimeout(r.cmd) {
+			i++
+			continue
+		}
+		if r.tries >= t.retries {
+			failed = append(failed, r.cmd)
+			t.remove(i)
+			continue
+		}
+		r.tries++
+		r.sent = now
+		t.send(r.cmd, r.payload)
+		i++
+	}
+	return failed
+}
+
+// Reset discards all outstanding requests
+func (t *Tracker) Reset() {
+	t.pending = t.pending[:0]
+}
+
+// RTT returns the last and smoothed round trip times
+func (t *Tracker) RTT() (time.Duration, time.Duration) {
+	return t.rtt, t.srtt
+}
@@ -0,0 +1,167 @@
+package msp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTrackerWaypoints(t *testing.T) {
+	var sent [][]byte
+	tr := NewTracker(func(cmd uint16, payload []byte) {
+		if cmd == MSP_SET_WP {
+			sent = append(sent, payload)
+		}
+	})
+	t0 := time.Unix(1000, 0)
+	wp255 := Waypoint{Number: 255, Action: wp_WAYPOINT, Lat: 1, Lon: 2}.Encode()
+	wp0 := Waypoint{Number: 0, Action: wp_WAYPOINT, Lat: 3, Lon: 4}.Encode()
+	tr.Request(MSP_SET_WP, wp255, t0)
+	tr.Request(MSP_SET_WP, wp0, t0.Add(10*time.Millisecond))
+	if len(tr.pending) != 2 {
+		t.Fatalf("pending %d, want 2", len(tr.pending))
+	}
+
+	// both lost, both resent
+	sent = nil
+	tr.Poll(t0.Add(DEFAULT_TIMEOUT + 20*time.Millisecond))
+	if len(sent) != 2 || sent[0][0] != 255 || sent[1][0] != 0 {
+		t.Fatalf("resent %v", sent)
+	}
+
+	// the acknowledgement completes the oldest
+	ack := MSPMsg{Cmd: MSP_SET_WP, Ok: true, Dir: DIR_RESPONSE}
+	rtt, err := tr.Reply(ack, t0.Add(DEFAULT_TIMEOUT+70*time.Millisecond))
+	if err != nil || rtt != 50*time.Millisecond {
+		t.Errorf("rtt %v err %v", rtt, err)
+	}
+	if len(tr.pending) != 1 || tr.pending[0].wpno != 0 {
+		t.Fatalf("pending %+v", tr.pending)
+	}
+
+	// a new WP255 does not replace WP0
+	tr.Request(MSP_SET_WP, wp255, t0.Add(time.Second))
+	if len(tr.pending) != 2 {
+		t.Errorf("pending %d, want 2", len(tr.pending))
+	}
+
+	// read backs are matched by number
+	tr.Request(MSP_WP, []byte{255}, t0.Add(time.Second))
+	tr.Request(MSP_WP, []byte{0}, t0.Add(time.Second))
+	rd := MSPMsg{Cmd: MSP_WP, Ok: true, Dir: DIR_RESPONSE, Data: []byte{0}}
+	tr.Reply(rd, t0.Add(time.Second+10*time.Millisecond))
+	if i := tr.find(MSP_WP, 255); i < 0 {
+		t.Error("WP255 read back completed by WP0 reply")
+	}
+	if i := tr.find(MSP_WP, 0); i >= 0 {
+		t.Error("WP0 read back still pending")
+	}
+}
+
+func TestTrackerPoll(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	ms := time.Millisecond
+	tests := []struct {
+		name    string
+		retries int
+		timeout time.Duration // MSP_WP override, 0 = none
+		cmd     uint16
+		polls   []time.Duration // from the request
+		sends   int             // including the request
+		failed  []uint16        // at the last poll
+	}{
+		{"answered in time", 3, 0, MSP_NAME, []time.Duration{499 * ms}, 1, nil},
+		{"resent", 3, 0, MSP_NAME, []time.Duration{500 * ms}, 2, nil},
+		{"retries exhausted", 2, 0, MSP_NAME, []time.Duration{500 * ms, 1000 * ms, 1500 * ms}, 3, []uint16{MSP_NAME}},
+		{"no retries", 0, 0, MSP_NAME, []time.Duration{500 * ms}, 1, []uint16{MSP_NAME}},
+		{"command timeout", 3, 2 * time.Second, MSP_WP, []time.Duration{1999 * ms}, 1, nil},
+		{"command timeout resend", 3, 2 * time.Second, MSP_WP, []time.Duration{1999 * ms, 2000 * ms}, 2, nil},
+		{"other commands default", 3, 2 * time.Second, MSP_NAME, []time.Duration{500 * ms}, 2, nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sends := 0
+			tr := NewTracker(func(uint16, []byte) { sends++ })
+			tr.SetRetries(tc.retries)
+			tr.SetCmdTimeout(MSP_WP, tc.timeout)
+			tr.Request(tc.cmd, []byte{1}, t0)
+			var failed []uint16
+			for _, d := range tc.polls {
+				failed = tr.Poll(t0.Add(d))
+			}
+			if sends != tc.sends {
+				t.Errorf("sent %d, want %d", sends, tc.sends)
+			}
+			if len(failed) != len(tc.failed) || (len(failed) > 0 && failed[0] != tc.failed[0]) {
+				t.Errorf("failed %v, want %v", failed, tc.failed)
+			}
+			if pending := tr.Pending(tc.cmd); pending != (tc.failed == nil) {
+				t.Errorf("pending %v", pending)
+			}
+		})
+	}
+
+	// 0 restores the default
+	tr := NewTracker(func(uint16, []byte) {})
+	tr.SetCmdTimeout(MSP_WP, 2*time.Second)
+	tr.SetCmdTimeout(MSP_WP, 0)
+	if d := tr.cmdTimeout(MSP_WP); d != DEFAULT_TIMEOUT {
+		t.Errorf("timeout %v after reset", d)
+	}
+}
+
+func TestTrackerReply(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	tests := []struct {
+		name    string
+		msg     MSPMsg
+		err     error
+		pending bool
+	}{
+		{"reply", MSPMsg{Cmd: MSP_NAME, Dir: DIR_RESPONSE, Ok: true}, nil, false},
+		{"nak", MSPMsg{Cmd: MSP_NAME, Dir: DIR_ERROR, Ok: true}, ErrNak, false},
+		{"bad checksum", MSPMsg{Cmd: MSP_NAME, Dir: DIR_RESPONSE}, ErrChecksum, true},
+		{"nak bad checksum", MSPMsg{Cmd: MSP_NAME, Dir: DIR_ERROR}, ErrChecksum, true},
+		{"unrequested", MSPMsg{Cmd: MSP_RAW_GPS, Dir: DIR_RESPONSE, Ok: true}, nil, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tr := NewTracker(func(uint16, []byte) {})
+			tr.Request(MSP_NAME, nil, t0)
+			_, err := tr.Reply(tc.msg, t0.Add(40*time.Millisecond))
+			if err != tc.err {
+				t.Errorf("err %v, want %v", err, tc.err)
+			}
+			if tr.Pending(MSP_NAME) != tc.pending {
+				t.Errorf("pending %v, want %v", !tc.pending, tc.pending)
+			}
+		})
+	}
+}
+
+func TestTrackerRTT(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	ms := time.Millisecond
+	tr := NewTracker(func(uint16, []byte) {})
+	// the first sets the smoothed value, then srtt += (rtt - srtt) / 8
+	tests := []struct {
+		rtt, srtt time.Duration
+	}{
+		{80 * ms, 80 * ms},
+		{160 * ms, 90 * ms},
+		{10 * ms, 80 * ms},
+	}
+	for j, tc := range tests {
+		start := t0.Add(time.Duration(j) * time.Second)
+		tr.Request(MSP_NAME, nil, start)
+		rtt, err := tr.Reply(MSPMsg{Cmd: MSP_NAME, Dir: DIR_RESPONSE, Ok: true}, start.Add(tc.rtt))
+		last, srtt := tr.RTT()
+		if err != nil || rtt != tc.rtt || last != tc.rtt || srtt != tc.srtt {
+			t.Errorf("reply %d: rtt %v last %v srtt %v, want %v %v", j, rtt, last, srtt, tc.rtt, tc.srtt)
+		}
+	}
+	// an unmatched reply leaves them
+	tr.Reply(MSPMsg{Cmd: MSP_NAME, Dir: DIR_RESPONSE, Ok: true}, t0.Add(time.Hour))
+	if last, srtt := tr.RTT(); last != 10*ms || srtt != 80*ms {
+		t.Errorf("unmatched reply: rtt %v srtt %v", last, srtt)
+	}
+}
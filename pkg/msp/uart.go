@@ -0,0 +1,53 @@
+//go:build tinygo
+
+package msp
+
+import (
+	"machine"
+	"time"
+)
+
+// UARTTransport is a Transport on a Pico UART
+type UARTTransport struct {
+	uart  machine.UART
+	delay time.Duration
+}
+
+func NewUARTTransport(uart machine.UART) *UARTTransport {
+	return &UARTTransport{uart: uart, delay: time.Millisecond}
+}
+
+func NewMSPUartReader(uart machine.UART, mchan chan MSPMsg) *MSPReader {
+	return NewMSPReader(NewUARTTransport(uart), mchan)
+}
+
+func (u *UARTTransport) SetBaud(baud uint32) error {
+	u.uart.SetBaudRate(baud)
+	u.delay = time.Duration((10 * 1000000 / (2 * baud))) * time.Microsecond
+	return nil
+}
+
+// Read blocks until at least one byte is available
+func (u *UARTTransport) Read(b []byte) (int, error) {
+	for u.uart.Buffered() == 0 {
+		time.Sleep(u.delay)
+	}
+	n := 0
+	for n < len(b) && u.uart.Buffered() > 0 {
+		c, err := u.uart.ReadByte()
+		if err != nil {
+			return n, err
+		}
+		b[n] = c
+		n++
+	}
+	return n, nil
+}
+
+func (u *UARTTransport) Write(b []byte) (int, error) {
+	return u.uart.Write(b)
+}
+
+func (u *UARTTransport) Close() error {
+	return nil
+}
@@ -0,0 +1,118 @@
+package msp
+
+import (
+	"math"
+)
+
+// INAV returns the vehicle position, not the target, for a MSP_WP read back
+// of the follow me waypoint, so it cannot be verified
+const WP_FOLLOW = 255
+
+// Result of checking a MSP_WP read back
+const (
+	WP_VERIFY_UNKNOWN = iota // not a waypoint awaiting verification
+	WP_VERIFY_OK
+	WP_VERIFY_RETRY // mismatch, the waypoint should be sent again
+	WP_VERIFY_FAILED
+)
+
+type wpCheck struct {
+	wp    Waypoint
+	tries int
+	asked bool // read back requested for wp
+	stale bool // read back requested for an earlier waypoint
+}
+
+// WpVerifier records waypoints sent with MSP_SET_WP and checks them against
+// the waypoint read back with MSP_WP. Read backs are made one at a time and
+// a reply is only checked against the waypoint it was requested for.
+type WpVerifier struct {
+	Retries   int
+	Tolerance float32 // metres
+	Failures  uint32
+	wps       []wpCheck
+}
+
+// Sent records a waypoint awaiting verification, replacing any earlier
+// unverified waypoint with the same number. WP_FOLLOW is not recorded.
+func (v *WpVerifier) Sent(wp Waypoint) {
+	if wp.Number == WP_FOLLOW {
+		return
+	}
+	for i := range v.wps {
+		c := &v.wps[i]
+		if c.wp.Number == wp.Number {
+			c.stale = c.asked
+			c.wp = wp
+			c.tries = 0
+			c.asked = false
+			return
+		}
+	}
+	v.wps = append(v.wps, wpCheck{wp: wp})
+}
+
+// Next returns the number of the next waypoint to read back, which is then
+// expected to be requested
+func (v *WpVerifier) Next() (uint8, bool) {
+	if len(v.wps) == 0 {
+		return 0, false
+	}
+	v.wps[0].asked = true
+	return v.wps[0].wp.Number, true
+}
+
+// Check compares a read back waypoint with the one sent. For
+// WP_VERIFY_RETRY the returned waypoint should be sent again.
+func (v *WpVerifier) Check(got Waypoint) (int, Waypoint) {
+	for i := range v.wps {
+		c := &v.wps[i]
+		if c.wp.Number != got.Number {
+			continue
+		}
+		if c.stale {
+			// read back of a waypoint since replaced
+			c.stale = false
+			return WP_VERIFY_UNKNOWN, got
+		}
+		if !c.asked {
+			return WP_VERIFY_UNKNOWN, got
+		}
+		c.asked = false
+		if c.wp.Action == got.Action && v.near(c.wp, got) {
+			v.wps = append(v.wps[:i], v.wps[i+1:]...)
+			return WP_VERIFY_OK, got
+		}
+		v.Failures++
+		if c.tries < v.Retries {
+			retry := wpCheck{wp: c.wp, tries: c.tries + 1}
+			// move to the back so other waypoints are not starved
+			v.wps = append(append(v.wps[:i], v.wps[i+1:]...), retry)
+			return WP_VERIFY_RETRY, retry.wp
+		}
+		wp := c.wp
+		v.wps = append(v.wps[:i], v.wps[i+1:]...)
+		return WP_VERIFY_FAILED, wp
+	}
+	return WP_VERIFY_UNKNOWN, got
+}
+
+// Lost forgets an unanswered read back, so the waypoint is read again
+func (v *WpVerifier) Lost() {
+	for i := range v.wps {
+		v.wps[i].asked = false
+		v.wps[i].stale = false
+	}
+}
+
+// Reset discards all unverified waypoints
+func (v *WpVerifier) Reset() {
+	v.wps = v.wps[:0]
+}
+
+func (v *WpVerifier) near(a, b Waypoint) bool {
+	// 1e-7 degree of latitude is 1.11cm
+	dlat := float64(a.Lat-b.Lat) * 0.0111
+	dlon := float64(a.Lon-b.Lon) * 0.0111 * math.Cos(float64(a.Lat)*math.Pi/1.8e9)
+	return math.Sqrt(dlat*dlat+dlon*dlon) <= float64(v.Tolerance)
+}
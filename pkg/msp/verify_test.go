@@ -0,0 +1,62 @@
+package msp
+
+import (
+	"testing"
+)
+
+func TestVerifier(t *testing.T) {
+	v := WpVerifier{Retries: 1, Tolerance: 1}
+	home := Waypoint{Number: 0, Action: wp_WAYPOINT, Lat: 515000000, Lon: -1000000}
+
+	v.Sent(Waypoint{Number: WP_FOLLOW, Action: wp_WAYPOINT, Lat: 1, Lon: 1})
+	if _, ok := v.Next(); ok {
+		t.Fatal("WP_FOLLOW recorded for verification")
+	}
+
+	// a reply that was not requested is not checked
+	v.Sent(home)
+	if res, _ := v.Check(home); res != WP_VERIFY_UNKNOWN {
+		t.Errorf("unrequested: %d", res)
+	}
+
+	if n, ok := v.Next(); !ok || n != 0 {
+		t.Fatalf("next %d %v", n, ok)
+	}
+	// replaced before the reply: the reply is for the old waypoint
+	moved := home
+	moved.Lat += 1000
+	v.Sent(moved)
+	if res, _ := v.Check(home); res != WP_VERIFY_UNKNOWN {
+		t.Errorf("stale: %d", res)
+	}
+	v.Next()
+	if res, _ := v.Check(moved); res != WP_VERIFY_OK {
+		t.Errorf("moved: %d", res)
+	}
+
+	// within tolerance
+	v.Sent(home)
+	v.Next()
+	near := home
+	near.Lat += 50 // 0.55m
+	if res, _ := v.Check(near); res != WP_VERIFY_OK {
+		t.Errorf("near: %d", res)
+	}
+
+	// mismatch, retry then failure
+	v.Sent(home)
+	v.Next()
+	if res, wp := v.Check(moved); res != WP_VERIFY_RETRY || wp != home {
+		t.Errorf("retry: %d %+v", res, wp)
+	}
+	v.Next()
+	if res, _ := v.Check(moved); res != WP_VERIFY_FAILED {
+		t.Errorf("failed: %d", res)
+	}
+	if v.Failures != 2 {
+		t.Errorf("failures %d", v.Failures)
+	}
+	if _, ok := v.Next(); ok {
+		t.Error("failed waypoint still recorded")
+	}
+}
@@ -165,6 +165,14 @@ func (o *OledDisplay) ShowGPS(nsat uint16, fix uint8) {
 	o.cEOL()
 }
 
+// ShowGPSText shows GPS set up progress (e.g. baud rate probing)
+func (o *OledDisplay) ShowGPSText(t string) {
+	o.setPos(6, OLED_ROW_GPS, 0)
+	o.d.PrintText(t)
+	o.incX(len(t))
+	o.cEOL()
+}
+
 func (o *OledDisplay) ShowINAVVers(t string) {
 	o.setPos(6, OLED_ROW_INAV, OLED_EXTRA_SPACE)
 	o.d.PrintText(t)
@@ -259,11 +267,35 @@ func (o *OledDisplay) ShowINAVPos(dist uint, brg uint16) {
 	o.d.PrintChar('*')
 }
 
+// ShowFenceHold replaces the position with the reason the follow
+// position was withheld by the geofence
+func (o *OledDisplay) ShowFenceHold(t string) {
+	o.setPos(6, OLED_ROW_VPOS, OLED_EXTRA_SPACE)
+	o.d.PrintText("!" + t)
+	o.incX(len(t) + 1)
+	o.cEOL()
+}
+
+// ShowFenceFlag shows a flag character after the position, for a follow
+// position clamped by the geofence
+func (o *OledDisplay) ShowFenceFlag(c byte) {
+	o.setPos(17, OLED_ROW_VPOS, OLED_EXTRA_SPACE)
+	o.d.PrintChar(c)
+}
+
 func (o *OledDisplay) ClearRow(row, offset int) {
 	o.setPos(6, row, offset)
 	o.cEOL()
 }
 
+func (o *OledDisplay) ClearINAVPos() {
+	o.ClearRow(OLED_ROW_VPOS, OLED_EXTRA_SPACE)
+}
+
+func (o *OledDisplay) ClearINAVSats() {
+	o.ClearRow(OLED_ROW_VSAT, OLED_EXTRA_SPACE)
+}
+
 func (o *OledDisplay) INAVReset() {
 	o.ShowINAVVers("?.?.?")
 	for j := OLED_ROW_MODE; j < OLED_ROW_COUNT; j++ {
@@ -0,0 +1,13 @@
+module transport
+
+go 1.19
+
+require (
+	go.bug.st/serial v1.4.0
+	golang.org/x/sys v0.0.0-20210823070655-63515b42dcdf
+	msp v1.0.0
+)
+
+require github.com/creack/goselect v0.1.2 // indirect
+
+replace msp v1.0.0 => ../msp
@@ -0,0 +1,70 @@
+package transport
+
+import (
+	"golang.org/x/sys/unix"
+	"os"
+	"strconv"
+)
+
+// PTY is the master side of a pseudo terminal; the other end opens Name()
+// as a serial device
+type PTY struct {
+	*os.File
+	slave *os.File
+	name  string
+}
+
+func NewPTY() (*PTY, error) {
+	m, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return nil, err
+	}
+	fd := int(m.Fd())
+	if err = unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		m.Close()
+		return nil, err
+	}
+	n, err := unix.IoctlGetInt(fd, unix.TIOCGPTN)
+	if err != nil {
+		m.Close()
+		return nil, err
+	}
+	name := "/dev/pts/" + strconv.Itoa(n)
+	// holding the slave open avoids EIO on the master while the other end
+	// is closed
+	s, err := os.OpenFile(name, os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		m.Close()
+		return nil, err
+	}
+	if err = makeRaw(int(s.Fd())); err != nil {
+		s.Close()
+		m.Close()
+		return nil, err
+	}
+	return &PTY{File: m, slave: s, name: name}, nil
+}
+
+func (p *PTY) Name() string {
+	return p.name
+}
+
+func (p *PTY) Close() error {
+	p.slave.Close()
+	return p.File.Close()
+}
+
+func makeRaw(fd int) error {
+	t, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return err
+	}
+	t.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	t.Oflag &^= unix.OPOST
+	t.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	t.Cflag &^= unix.CSIZE | unix.PARENB
+	t.Cflag |= unix.CS8
+	t.Cc[unix.VMIN] = 1
+	t.Cc[unix.VTIME] = 0
+	return unix.IoctlSetTermios(fd, unix.TCSETS, t)
+}
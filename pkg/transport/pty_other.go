@@ -0,0 +1,20 @@
+//go:build !linux
+
+package transport
+
+import (
+	"errors"
+	"msp"
+)
+
+type PTY struct {
+	msp.Transport
+}
+
+func NewPTY() (*PTY, error) {
+	return nil, errors.New("PTY not supported")
+}
+
+func (p *PTY) Name() string {
+	return ""
+}
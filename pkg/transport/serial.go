@@ -0,0 +1,21 @@
+package transport
+
+import (
+	"go.bug.st/serial"
+)
+
+type Serial struct {
+	serial.Port
+}
+
+func NewSerial(name string, baud int) (*Serial, error) {
+	p, err := serial.Open(name, &serial.Mode{BaudRate: baud})
+	if err != nil {
+		return nil, err
+	}
+	return &Serial{p}, nil
+}
+
+func (s *Serial) SetBaud(baud uint32) error {
+	return s.SetMode(&serial.Mode{BaudRate: int(baud)})
+}
@@ -0,0 +1,75 @@
+package transport
+
+import (
+	"net"
+	"sync"
+)
+
+func NewTCPClient(addr string) (net.Conn, error) {
+	return net.Dial("tcp", addr)
+}
+
+// TCPServer serves one client at a time; when the client disconnects the
+// next is accepted. Writes without a client are discarded.
+type TCPServer struct {
+	l    net.Listener
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func NewTCPServer(addr string) (*TCPServer, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &TCPServer{l: l}, nil
+}
+
+func (t *TCPServer) Addr() net.Addr {
+	return t.l.Addr()
+}
+
+func (t *TCPServer) Read(b []byte) (int, error) {
+	for {
+		t.mu.Lock()
+		c := t.conn
+		t.mu.Unlock()
+		if c == nil {
+			nc, err := t.l.Accept()
+			if err != nil {
+				return 0, err
+			}
+			t.mu.Lock()
+			t.conn = nc
+			t.mu.Unlock()
+			continue
+		}
+		if n, _ := c.Read(b); n > 0 {
+			return n, nil
+		}
+		// client gone
+		c.Close()
+		t.mu.Lock()
+		t.conn = nil
+		t.mu.Unlock()
+	}
+}
+
+func (t *TCPServer) Write(b []byte) (int, error) {
+	t.mu.Lock()
+	c := t.conn
+	t.mu.Unlock()
+	if c == nil {
+		return len(b), nil
+	}
+	return c.Write(b)
+}
+
+func (t *TCPServer) Close() error {
+	t.mu.Lock()
+	if t.conn != nil {
+		t.conn.Close()
+	}
+	t.mu.Unlock()
+	return t.l.Close()
+}
@@ -0,0 +1,69 @@
+package transport
+
+/* Host MSP (or GPS) links. The link is named by a device node or URL:
+ *
+ *	/dev/ttyUSB0             serial device
+ *	tcp://host:port          TCP client (e.g. INAV SITL, ser2net)
+ *	tcp://:port              TCP server, accepting one client at a time
+ *	udp://host:port          UDP to (only) host:port (ESP8266 / ESP32 bridges)
+ *	udp://host:port?bind=n   ... receiving on local port n
+ *	udp://:port              UDP server, replying to the last peer
+ *	pty                      new pseudo terminal, see Name()
+ */
+
+import (
+	"errors"
+	"msp"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+var ErrScheme = errors.New("Unsupported transport")
+
+// Named is implemented by transports that create a device node (PTY)
+type Named interface {
+	Name() string
+}
+
+// Open returns the transport for name; baud applies to serial devices
+func Open(name string, baud int) (msp.Transport, error) {
+	t, err := open(name, baud)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// open may return a typed nil transport on error
+func open(name string, baud int) (msp.Transport, error) {
+	if name == "pty" || name == "pty://" {
+		return NewPTY()
+	}
+	if !strings.Contains(name, "://") {
+		return NewSerial(name, baud)
+	}
+	u, err := url.Parse(name)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "tcp":
+		if u.Hostname() == "" {
+			return NewTCPServer(u.Host)
+		}
+		return NewTCPClient(u.Host)
+	case "udp":
+		bind := 0
+		if b := u.Query().Get("bind"); b != "" {
+			if bind, err = strconv.Atoi(b); err != nil {
+				return nil, err
+			}
+		}
+		if u.Hostname() == "" {
+			return NewUDPServer(u.Host)
+		}
+		return NewUDPClient(u.Host, bind)
+	}
+	return nil, ErrScheme
+}
@@ -0,0 +1,149 @@
+package transport
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// readWithin reads from r, failing the test if nothing arrives within 2s
+func readWithin(t *testing.T, r io.Reader) []byte {
+	t.Helper()
+	type result struct {
+		b   []byte
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		b := make([]byte, 256)
+		n, err := r.Read(b)
+		ch <- result{b[:n], err}
+	}()
+	select {
+	case res := <-ch:
+		if res.err != nil {
+			t.Fatal(res.err)
+		}
+		return res.b
+	case <-time.After(2 * time.Second):
+		t.Fatal("read timed out")
+	}
+	return nil
+}
+
+func TestTCP(t *testing.T) {
+	srv, err := NewTCPServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	// without a client, writes are discarded
+	if n, err := srv.Write([]byte("lost")); n != 4 || err != nil {
+		t.Errorf("write without client: %d %v", n, err)
+	}
+
+	cl, err := Open("tcp://"+srv.Addr().String(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	req := []byte("$X<\x00d\x00\x00\x00\x8f")
+	cl.Write(req)
+	if got := readWithin(t, srv); !bytes.Equal(got, req) {
+		t.Errorf("server read % x, want % x", got, req)
+	}
+	rep := []byte("reply")
+	srv.Write(rep)
+	if got := readWithin(t, cl); !bytes.Equal(got, rep) {
+		t.Errorf("client read %q, want %q", got, rep)
+	}
+}
+
+func TestUDP(t *testing.T) {
+	srv, err := NewUDPServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	cl, err := Open("udp://"+srv.conn.LocalAddr().String(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	cl.Write([]byte("ping"))
+	if got := readWithin(t, srv); string(got) != "ping" {
+		t.Errorf("server read %q", got)
+	}
+	// the server replies to the last peer
+	srv.Write([]byte("pong"))
+	if got := readWithin(t, cl); string(got) != "pong" {
+		t.Errorf("client read %q", got)
+	}
+
+	// a datagram from elsewhere does not redirect the client
+	other, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	port := cl.(*UDP).conn.LocalAddr().(*net.UDPAddr).Port
+	other.WriteToUDP([]byte("stray"), &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+	if got := readWithin(t, cl); string(got) != "stray" {
+		t.Errorf("client read %q", got)
+	}
+	cl.Write([]byte("again"))
+	if got := readWithin(t, srv); string(got) != "again" {
+		t.Errorf("server read %q after a stray datagram", got)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	tests := []struct {
+		name string
+		ok   bool
+		kind string
+	}{
+		{"tcp://:0", true, "server"},
+		{"udp://:0", true, "udp server"},
+		{"udp://127.0.0.1:5760", true, "udp client"},
+		{"udp://127.0.0.1:5760?bind=0", true, "udp client"},
+		{"udp://127.0.0.1:5760?bind=x", false, ""},
+		{"ftp://127.0.0.1:21", false, ""},
+		{"tcp://%zz", false, ""},
+		{"tcp://127.0.0.1:port", false, ""},
+		{"/dev/nonexistent-tty", false, ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tr, err := Open(tc.name, 115200)
+			if (err == nil) != tc.ok {
+				t.Fatalf("err %v, want ok %v", err, tc.ok)
+			}
+			if err != nil {
+				if tr != nil {
+					t.Error("transport not nil on error")
+				}
+				return
+			}
+			defer tr.Close()
+			switch v := tr.(type) {
+			case *TCPServer:
+				if tc.kind != "server" {
+					t.Errorf("TCP server, want %s", tc.kind)
+				}
+			case *UDP:
+				if v.fixed != (tc.kind == "udp client") {
+					t.Errorf("UDP fixed peer %v, want %s", v.fixed, tc.kind)
+				}
+			default:
+				t.Errorf("%T, want %s", tr, tc.kind)
+			}
+		})
+	}
+	if _, err := Open("ftp://127.0.0.1:21", 0); err != ErrScheme {
+		t.Errorf("unknown scheme: %v", err)
+	}
+}
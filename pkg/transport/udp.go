@@ -0,0 +1,72 @@
+package transport
+
+import (
+	"net"
+	"sync"
+)
+
+// UDP sends to a fixed peer (client) or to the last peer heard (server)
+type UDP struct {
+	conn  *net.UDPConn
+	mu    sync.Mutex
+	peer  *net.UDPAddr
+	fixed bool
+}
+
+// NewUDPClient sends to addr; replies are received on the local port bind
+// (0 for any)
+func NewUDPClient(addr string, bind int) (*UDP, error) {
+	peer, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: bind})
+	if err != nil {
+		return nil, err
+	}
+	return &UDP{conn: conn, peer: peer, fixed: true}, nil
+}
+
+func NewUDPServer(addr string) (*UDP, error) {
+	la, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", la)
+	if err != nil {
+		return nil, err
+	}
+	return &UDP{conn: conn}, nil
+}
+
+func (u *UDP) Read(b []byte) (int, error) {
+	for {
+		n, addr, err := u.conn.ReadFromUDP(b)
+		if err != nil {
+			return n, err
+		}
+		if n > 0 {
+			if !u.fixed {
+				u.mu.Lock()
+				u.peer = addr
+				u.mu.Unlock()
+			}
+			return n, nil
+		}
+	}
+}
+
+// Write discards data until a peer is known
+func (u *UDP) Write(b []byte) (int, error) {
+	u.mu.Lock()
+	peer := u.peer
+	u.mu.Unlock()
+	if peer == nil {
+		return len(b), nil
+	}
+	return u.conn.WriteToUDP(b, peer)
+}
+
+func (u *UDP) Close() error {
+	return u.conn.Close()
+}
@@ -1,11 +1,39 @@
 package main
 
+import (
+	"fence"
+	"gps"
+)
+
 /* user preferences */
 const (
 	// Baud rate for MSP
 	MSPBAUD = 115200
+	// MSP protocol version, 0 = auto detect, 1 = MSPv1, 2 = MSPv2
+	MSPVERSION = 0
+	// MSP request retries and reply timeout (ms)
+	MSP_RETRIES     = 3
+	MSP_REQ_TIMEOUT = 500
+	// Reply timeout (ms) for waypoint writes and read backs (MSP_SET_WP,
+	// MSP_WP), which the FC may answer more slowly; 0 = MSP_REQ_TIMEOUT
+	MSP_WP_TIMEOUT = 1000
 	// Baud rate for GPS
 	GPSBAUD = 9600
+	// Probe for the receiver's baud rate (NMEA or UBX) at start up,
+	// GPSBAUD first
+	GPS_AUTOBAUD = true
+	// Receiver configuration at start up; gps.RX_NONE = use the receiver's
+	// settings, else (gps.RX_UBLOX, RX_MTK, RX_QUECTEL) set GPSBAUD, GPS_RATE
+	// (Hz, 0 = receiver default) and GPS_GNSS (0 = receiver default, else
+	// gps.GNSS_GPS|gps.GNSS_GLONASS|...). u-blox receivers are switched to UBX
+	// binary output; older ones (e.g. Neo-6M, max. 5Hz) do not support
+	// NAV-PVT, set GPS_UBX_PVT false.
+	GPS_TYPE    = gps.RX_NONE
+	GPS_RATE    = 5
+	GPS_GNSS    = 0
+	GPS_UBX_PVT = true
+	// Dynamic model (gps.DYN_PORTABLE, DYN_PEDESTRIAN, DYN_AUTOMOTIVE)
+	GPS_UBX_MODEL = gps.DYN_PEDESTRIAN
 	// Minimum user sats for follow me
 	GPSMINSAT = 6
 	// Craft type for no follow (1 = FW)
@@ -23,6 +51,56 @@ const (
 
 	// if true, the HOME location will also be set to the follow me location
 	RESET_HOME = false
+
+	// Follow position, 0 = onto the user, 1 = FOLLOW_DIST (m) from the user on
+	// FOLLOW_BEARING (relative to north), 2 = FOLLOW_DIST on FOLLOW_BEARING
+	// relative to the user's course, 3 = FOLLOW_DIST behind the user (chase)
+	FOLLOW_MODE    = 0
+	FOLLOW_DIST    = 10
+	FOLLOW_BEARING = 180
+
+	// Kalman filter the user's position; fixes with HDOP > KF_MAX_HDOP are
+	// rejected; KF_ACCEL is the expected user acceleration (m/s²)
+	KF_ENABLE           = false
+	KF_MAX_HDOP         = 5.0
+	KF_ACCEL    float32 = 1.0
+
+	// Lead the user by their velocity times (MSP latency + LEAD_HORIZON (s)),
+	// capped at LEAD_MAX (m); LEAD_MAX = 0 disables prediction
+	LEAD_HORIZON = 1.0
+	LEAD_MAX     = 0
+
+	// Geofence; maximum distance (m) of the follow position from the
+	// vehicle's home and maximum movement (m) per update. 0 disables.
+	// Inclusion / exclusion zones are defined by FENCE_ZONES below.
+	FENCE_RANGE = 0
+	FENCE_STEP  = 0
+
+	// Follow me altitude, 0 = hold vehicle altitude, 1 = ALT_OFFSET (m) above
+	// the user, 2 = keep the height above the user at engagement.
+	// For 1 and 2, the height above the user is clamped to ALT_MIN - ALT_MAX
+	// (m) unless ALT_MAX <= ALT_MIN
+	ALT_MODE   = 0
+	ALT_OFFSET = 10
+	ALT_MIN    = 5
+	ALT_MAX    = 50
+
+	// if true, waypoints are read back (MSP_WP) and resent if they differ
+	WP_VERIFY = false
+	// Read back retries and position tolerance (m)
+	WP_VERIFY_RETRIES           = 2
+	WP_VERIFY_TOLERANCE float32 = 1.0
 )
 
+/* Geofence zones. A zone is a circle (one point and a radius (m)) or a
+ * polygon (three or more points). Exclusion zones are keep out areas; if
+ * any inclusion zones are defined, the follow position must be inside one.
+ * For example:
+ *
+ *	{Exclude: true, Radius: 30, Points: []fence.Point{{35.7610, 140.3789}}},
+ *	{Points: []fence.Point{{35.760, 140.377}, {35.762, 140.377},
+ *		{35.762, 140.381}, {35.760, 140.381}}},
+ */
+var FENCE_ZONES = []fence.Zone{}
+
 /* End of user preferences */
@@ -4,8 +4,17 @@ go 1.17
 
 require (
 	github.com/eiannone/keyboard v0.0.0-20200508000154-caf4b762e807
-	go.bug.st/serial v1.3.5
+	go.bug.st/serial v1.4.0
 	golang.org/x/sys v0.0.0-20210823070655-63515b42dcdf
 )
 
 require github.com/creack/goselect v0.1.2 // indirect
+
+require (
+	msp v1.0.0
+	transport v1.0.0
+)
+
+replace msp v1.0.0 => ../../pkg/msp
+
+replace transport v1.0.0 => ../../pkg/transport
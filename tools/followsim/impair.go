@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"msp"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* Link impairment, applied in both directions to each frame (or, for
+ * requests, each read from the device, which is in practice a frame).
+ * Frames share the link at the given bandwidth and are then delayed by
+ * latency plus a random jitter; a jitter greater than the frame spacing
+ * reorders frames. */
+
+type Impairment struct {
+	Loss      float64       // probability of losing a frame
+	Latency   time.Duration // added delay
+	Jitter    time.Duration // maximum random additional delay
+	BER       float64       // bit error rate
+	Truncate  float64       // probability of truncating a frame
+	Bandwidth int           // bits/s (10 bits / byte), 0 = unlimited
+}
+
+// Approximations of common telemetry radios
+var linkPresets = map[string]Impairment{
+	"none": {},
+	// HC-12, FU3 mode, 9600 baud
+	"hc12": {Loss: 0.01, Latency: 20 * time.Millisecond, Jitter: 10 * time.Millisecond,
+		BER: 1e-5, Truncate: 0.002, Bandwidth: 9600},
+	// LoRa (SX127x) serial bridge, ~2.4kbps air rate
+	"lora": {Loss: 0.05, Latency: 120 * time.Millisecond, Jitter: 60 * time.Millisecond,
+		BER: 1e-4, Truncate: 0.01, Bandwidth: 2400},
+}
+
+func LinkPreset(name string) (Impairment, error) {
+	if imp, ok := linkPresets[strings.ToLower(name)]; ok {
+		return imp, nil
+	}
+	var names []string
+	for k := range linkPresets {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return Impairment{}, fmt.Errorf("unknown link \"%s\" (%s)", name, strings.Join(names, ", "))
+}
+
+func (imp Impairment) IsZero() bool {
+	return imp == Impairment{}
+}
+
+func (imp Impairment) String() string {
+	return fmt.Sprintf("loss %.1f%%, latency %v, jitter %v, BER %g, truncate %.1f%%, bandwidth %d",
+		imp.Loss*100, imp.Latency, imp.Jitter, imp.BER, imp.Truncate*100, imp.Bandwidth)
+}
+
+// lane is one direction of the link
+type lane struct {
+	imp  Impairment
+	mu   sync.Mutex // busy
+	busy time.Time
+	out  func([]byte) // safe for concurrent use
+}
+
+func (l *lane) send(b []byte) {
+	if rand.Float64() < l.imp.Loss {
+		return
+	}
+	f := make([]byte, len(b))
+	copy(f, b)
+	if len(f) > 1 && rand.Float64() < l.imp.Truncate {
+		f = f[:1+rand.Intn(len(f)-1)]
+	}
+	if l.imp.BER > 0 {
+		for j := range f {
+			for k := 0; k < 8; k++ {
+				if rand.Float64() < l.imp.BER {
+					f[j] ^= 1 << k
+				}
+			}
+		}
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	if l.busy.Before(now) {
+		l.busy = now
+	}
+	if l.imp.Bandwidth > 0 {
+		l.busy = l.busy.Add(time.Duration(len(f)*10) * time.Second / time.Duration(l.imp.Bandwidth))
+	}
+	at := l.busy.Add(l.imp.Latency)
+	l.mu.Unlock()
+	if l.imp.Jitter > 0 {
+		at = at.Add(time.Duration(rand.Int63n(int64(l.imp.Jitter))))
+	}
+	// f is this frame's own copy; no lock is held while it is written, so
+	// a slow reader does not hold up later frames
+	time.AfterFunc(time.Until(at), func() { l.out(f) })
+}
+
+// ImpairedLink is a msp.Transport applying an Impairment to another
+type ImpairedLink struct {
+	t    msp.Transport
+	up   *lane
+	down *lane
+	pr   *io.PipeReader
+}
+
+func NewImpairedLink(t msp.Transport, imp Impairment) *ImpairedLink {
+	pr, pw := io.Pipe()
+	l := &ImpairedLink{t: t, pr: pr}
+	l.up = &lane{imp: imp, out: func(b []byte) { pw.Write(b) }}
+	l.down = &lane{imp: imp, out: func(b []byte) {
+		if _, err := t.Write(b); err != nil {
+			fmt.Fprintf(os.Stderr, "Write error: %v\n", err)
+		}
+	}}
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, err := t.Read(buf)
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			l.up.send(buf[:n])
+		}
+	}()
+	return l
+}
+
+func (l *ImpairedLink) Read(b []byte) (int, error) {
+	return l.pr.Read(b)
+}
+
+func (l *ImpairedLink) Write(b []byte) (int, error) {
+	l.down.send(b)
+	return len(b), nil
+}
+
+func (l *ImpairedLink) Close() error {
+	l.pr.Close()
+	return l.t.Close()
+}
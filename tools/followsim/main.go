@@ -6,19 +6,16 @@ import (
 	"github.com/eiannone/keyboard"
 	"log"
 	"math/rand"
+	"msp"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
-type SChan struct {
-	len  uint16
-	cmd  uint16
-	ok   bool
-	data []byte
-}
-
 var BaseLat float64
 var BaseLon float64
+var HomeAlt float64
 
 func main() {
 	BaseLat = -90.0 + rand.Float64()*180.0
@@ -31,11 +28,89 @@ func main() {
 
 	flag.Float64Var(&BaseLat, "lat", 0, "Base latitude")
 	flag.Float64Var(&BaseLon, "lon", 0, "Base longitude")
+	flag.Float64Var(&HomeAlt, "alt", 40, "Home altitude (m AMSL)")
+	mspvers := flag.Int("mspvers", 0, "Accepted MSP version (0 = any, 1 = MSPv1, 2 = MSPv2)")
+	maxspeed := flag.Float64("max-speed", 10.0, "Vehicle maximum speed (m/s)")
+	accel := flag.Float64("accel", 2.5, "Vehicle acceleration (m/s/s)")
+	turnrate := flag.Float64("turn-rate", 45.0, "Vehicle turn rate (deg/s)")
+	platform := flag.String("platform", "tricopter", "Platform type (multirotor, airplane, helicopter, tricopter, rover, boat or number)")
+	variant := flag.String("variant", "INAV", "Firmware variant (INAV, BTFL, ARDU ...)")
+	fcvers := flag.String("fc-version", "6.6.6", "Firmware version")
+	name := flag.String("name", "Follower", "Craft name")
+	link := flag.String("link", "none", "Link impairment preset (none, hc12, lora)")
+	loss := flag.Float64("loss", 0, "Frame loss (%)")
+	latency := flag.Duration("latency", 0, "Link latency")
+	jitter := flag.Duration("jitter", 0, "Maximum additional random latency")
+	ber := flag.Float64("ber", 0, "Bit error rate")
+	truncate := flag.Float64("truncate", 0, "Truncated frames (%)")
+	bandwidth := flag.Int("bandwidth", 0, "Link bandwidth (bits/s, 0 = unlimited)")
+	record := flag.String("record", "", "Record the MSP session to file (JSON lines)")
+	replay := flag.String("replay", "", "Replay the replies from a recorded session or raw MSP capture")
+	replaySync := flag.Bool("replay-sync", false, "Replay each reply on request, rather than at the recorded time")
+	scenario := flag.String("scenario", "", "Scenario file (JSON), runs without keyboard input")
 	flag.Parse()
 
+	state := NewSimState()
+	var sc *Scenario
+	if *scenario != "" {
+		var err error
+		sc, err = LoadScenario(*scenario)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err = state.Configure(sc.Settings); err != nil {
+			log.Fatalf("%s: %v\n", *scenario, err)
+		}
+	}
+	var rp *Replay
+	if *replay != "" {
+		var err error
+		rp, err = LoadReplay(*replay)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *replaySync || !rp.Timed() {
+			rp.SetSync(true)
+		}
+	}
+
+	imp, err := LinkPreset(*link)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// explicit options override the scenario and link preset
+	var st Settings
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "loss":
+			imp.Loss = *loss / 100
+		case "latency":
+			imp.Latency = *latency
+		case "jitter":
+			imp.Jitter = *jitter
+		case "ber":
+			imp.BER = *ber
+		case "truncate":
+			imp.Truncate = *truncate / 100
+		case "bandwidth":
+			imp.Bandwidth = *bandwidth
+		case "platform":
+			st.Platform = *platform
+		case "variant":
+			st.Variant = *variant
+		case "fc-version":
+			st.Version = *fcvers
+		case "name":
+			st.Name = *name
+		}
+	})
+	if err = state.Configure(st); err != nil {
+		log.Fatal(err)
+	}
+
 	var sp *MSPSerial
 	port := ""
-	c0 := make(chan SChan)
+	c0 := make(chan msp.MSPMsg)
 
 	files := flag.Args()
 	if len(files) > 0 {
@@ -46,22 +121,100 @@ func main() {
 	if port != "" {
 		fmt.Printf("Using %s\n", port)
 		sp = NewMSPSerial(port)
-		go sp.Reader(c0)
+		if *record != "" {
+			fh, err := os.Create(*record)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer fh.Close()
+			sp.Transport = NewRecorder(sp.Transport, fh)
+		}
+		if !imp.IsZero() {
+			fmt.Printf("Link: %v\n", imp)
+			sp.Transport = NewImpairedLink(sp.Transport, imp)
+		}
+		go sp.Reader(c0, byte(*mspvers))
 	} else {
 		log.Fatalln("No serial device given or detected")
 	}
 
-	keysEvents, err := keyboard.GetKeys(10)
-	if err != nil {
-		panic(err)
+	var keysEvents <-chan keyboard.KeyEvent
+	if sc == nil && rp == nil {
+		keysEvents, err = keyboard.GetKeys(10)
+		if err != nil {
+			panic(err)
+		}
+		defer keyboard.Close()
 	}
-	defer keyboard.Close()
 
-	armed := false
-	poshold := false
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
+	veh := NewVehicle(BaseLat, BaseLon, *maxspeed, *accel, *turnrate)
+	var fwp msp.Waypoint
+	havewp := false
+	mode := state.Mode()
+
+	start := time.Now()
+	last := start
+	var rstart, rend time.Time
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
 
 	for done := false; !done; {
 		select {
+		case <-sigs:
+			done = true
+		case now := <-ticker.C:
+			if rp != nil {
+				if !rstart.IsZero() && !rp.sync {
+					for _, b := range rp.Due(now.Sub(rstart).Seconds()) {
+						sp.Write(b)
+					}
+				}
+				// allow the last reply to be delivered
+				if rp.Done() {
+					if rend.IsZero() {
+						fmt.Printf("%s replay complete\n", now.Format("15:04:05.0"))
+						rend = now.Add(time.Second)
+					} else if now.After(rend) {
+						done = true
+					}
+				}
+			}
+			if sc != nil {
+				for _, ev := range sc.Due(now.Sub(start).Seconds()) {
+					fmt.Printf("%s event %s", now.Format("15:04:05.0"), ev.Event)
+					switch ev.Event {
+					case "nak", "ack":
+						fmt.Printf(" %d", ev.Cmd)
+					case "platform":
+						fmt.Printf(" %d", ev.Value)
+					}
+					fmt.Println()
+					if !state.Apply(ev) {
+						done = true
+					}
+				}
+			}
+			if m := state.Mode(); m != mode {
+				// a follow waypoint only applies to the POSHOLD it was set in
+				mode = m
+				havewp = false
+			}
+			switch {
+			case mode == msp.NAV_MODE_HOLD && havewp:
+				veh.SetTarget(float64(fwp.Lat)/1e7, float64(fwp.Lon)/1e7)
+				if fwp.Alt != 0 {
+					veh.Alt = float64(fwp.Alt) / 100
+				}
+			case mode == msp.NAV_MODE_RTH:
+				veh.SetTarget(BaseLat, BaseLon)
+			default:
+				veh.ClearTarget()
+			}
+			veh.Update(now.Sub(last).Seconds())
+			last = now
 		case ev := <-keysEvents:
 			if ev.Err != nil {
 				panic(ev.Err)
@@ -69,12 +222,19 @@ func main() {
 			if ev.Key == 0 {
 				switch ev.Rune {
 				case 'a', 'A':
-					armed = !armed
-					fmt.Printf("Armed: %v\n", armed)
-					poshold = false
+					if state.Armed {
+						state.Apply(Event{Event: "disarm"})
+					} else {
+						state.Apply(Event{Event: "arm"})
+					}
+					fmt.Printf("Armed: %v\n", state.Armed)
 				case 'p', 'P':
-					poshold = !poshold
-					fmt.Printf("Poshold: %v\n", poshold)
+					if state.NavMode == msp.NAV_MODE_HOLD {
+						state.Apply(Event{Event: "idle"})
+					} else {
+						state.Apply(Event{Event: "poshold"})
+					}
+					fmt.Printf("Poshold: %v\n", state.NavMode == msp.NAV_MODE_HOLD)
 				case 'Q', 'q':
 					done = true
 				default:
@@ -87,35 +247,74 @@ func main() {
 				}
 			}
 		case v := <-c0:
+			if v.Cmd == 0 {
+				done = true
+				break
+			}
+			if state.Silent {
+				continue
+			}
+			sp.vers = v.Vers
 			st := time.Now()
 			fmt.Printf("%s ", st.Format("15:04:05.0"))
-			switch v.cmd {
-			case MSP_FC_VARIANT:
+			if rp != nil {
+				if rstart.IsZero() {
+					rstart = st
+				}
+				if !rp.sync {
+					fmt.Printf("request %d\n", v.Cmd)
+				} else if b, ok := rp.Reply(v.Cmd); ok {
+					fmt.Printf("replay %d\n", v.Cmd)
+					sp.Write(b)
+				} else {
+					fmt.Printf("no reply for %d\n", v.Cmd)
+				}
+				continue
+			}
+			if state.Nak[v.Cmd] {
+				fmt.Printf("NAK %d\n", v.Cmd)
+				sp.SendAckNak(v.Cmd, false)
+				continue
+			}
+			switch v.Cmd {
+			case msp.MSP_FC_VARIANT:
 				fmt.Println("send varient")
-				sp.SendVariant()
-			case MSP_FC_VERSION:
+				sp.SendVariant(state.Variant)
+			case msp.MSP_FC_VERSION:
 				fmt.Println("send version")
-				sp.SendVersion()
-			case MSP2_INAV_MIXER:
+				sp.SendVersion(state.Version)
+			case msp.MSP2_INAV_MIXER:
+				if !state.IsINAV() {
+					fmt.Printf("NAK mixer (%s)\n", state.Variant)
+					sp.SendAckNak(v.Cmd, false)
+					break
+				}
 				fmt.Println("send mixer")
-				sp.SendMixer()
-			case MSP_NAME:
+				sp.SendMixer(state.Platform)
+			case msp.MSP_NAME:
 				fmt.Println("send name")
-				sp.SendName()
-			case MSP_RAW_GPS:
+				sp.SendName(state.Name)
+			case msp.MSP_RAW_GPS:
 				fmt.Println("send GPS")
-				sp.SendGPS(armed)
-			case MSP_SET_WP:
+				sp.SendGPS(state, veh)
+			case msp.MSP_ALTITUDE:
+				fmt.Println("send altitude")
+				sp.SendAltitude(veh)
+			case msp.MSP_SET_WP:
 				fmt.Println("Set WP")
-				sp.deserialise_wp(v.data)
-			case MSP_NAV_STATUS:
-				fmt.Printf("send nav status (arm %v, hold %v)\n", armed, poshold)
-				sp.SendStatus(armed, poshold)
-			case 0:
-				done = true
+				if wp, ok := sp.deserialise_wp(v.Data); ok && wp.Number == 255 && mode == msp.NAV_MODE_HOLD {
+					fwp = wp
+					havewp = true
+				}
+			case msp.MSP_WP:
+				fmt.Println("send WP")
+				sp.SendWP(v.Data, veh)
+			case msp.MSP_NAV_STATUS:
+				fmt.Printf("send nav status (arm %v, mode %d)\n", state.Armed, state.Mode())
+				sp.SendStatus(state)
 			default:
-				fmt.Printf("Unexpected MSP %d (0x%x)\n", v.cmd, v.cmd)
-				sp.SendAckNak(v.cmd, false)
+				fmt.Printf("Unexpected MSP %d (0x%x)\n", v.Cmd, v.Cmd)
+				sp.SendAckNak(v.Cmd, false)
 			}
 		}
 	}
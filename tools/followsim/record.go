@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"msp"
+	"os"
+	"sync"
+	"time"
+)
+
+/* Session logs are JSON lines, one per frame (or device read), e.g.
+ *
+ *  {"t":1.204,"dir":"rx","data":"24580000640000008f"}
+ *
+ * "t" is seconds from the start of recording; "dir" is "rx" for data
+ * received by the simulator (requests), "tx" for data sent (replies).
+ */
+
+type Record struct {
+	T    float64 `json:"t"`
+	Dir  string  `json:"dir"`
+	Data string  `json:"data"`
+}
+
+// Recorder is a msp.Transport logging all traffic through another
+type Recorder struct {
+	msp.Transport
+	mu    sync.Mutex
+	enc   *json.Encoder
+	start time.Time
+}
+
+func NewRecorder(t msp.Transport, w io.Writer) *Recorder {
+	return &Recorder{Transport: t, enc: json.NewEncoder(w), start: time.Now()}
+}
+
+func (r *Recorder) record(dir string, b []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.enc.Encode(Record{T: time.Since(r.start).Seconds(), Dir: dir, Data: hex.EncodeToString(b)})
+}
+
+func (r *Recorder) Read(b []byte) (int, error) {
+	n, err := r.Transport.Read(b)
+	if n > 0 {
+		r.record("rx", b[:n])
+	}
+	return n, err
+}
+
+func (r *Recorder) Write(b []byte) (int, error) {
+	r.record("tx", b)
+	return r.Transport.Write(b)
+}
+
+type replayFrame struct {
+	t    float64
+	cmd  uint16
+	ok   bool // decodes as a valid frame
+	data []byte
+	used bool
+}
+
+// Replay holds the replies (FC to ground station) from a session log or
+// from a raw capture of MSP traffic (which has no timing)
+type Replay struct {
+	frames []*replayFrame
+	next   int
+	timed  bool
+	sync   bool
+}
+
+func LoadReplay(name string) (*Replay, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	r := &Replay{}
+	if t := bytes.TrimSpace(b); len(t) > 0 && t[0] == '{' {
+		err = r.loadLog(b)
+	} else {
+		err = r.loadRaw(b)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if len(r.frames) == 0 {
+		return nil, fmt.Errorf("%s: no replies found", name)
+	}
+	return r, nil
+}
+
+func (r *Replay) loadLog(b []byte) error {
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for ln := 1; sc.Scan(); ln++ {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return fmt.Errorf("line %d: %w", ln, err)
+		}
+		if rec.Dir != "tx" {
+			continue
+		}
+		data, err := hex.DecodeString(rec.Data)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", ln, err)
+		}
+		f := &replayFrame{t: rec.T, data: data}
+		if msg, err := msp.NewStreamDecoder(bytes.NewReader(data)).Next(); err == nil {
+			f.cmd = msg.Cmd
+			f.ok = msg.Ok
+		}
+		r.frames = append(r.frames, f)
+	}
+	r.timed = true
+	return sc.Err()
+}
+
+func (r *Replay) loadRaw(b []byte) error {
+	sd := msp.NewStreamDecoder(bytes.NewReader(b))
+	for {
+		msg, err := sd.Next()
+		if err != nil {
+			break
+		}
+		if msg.Ok && msg.Dir != msp.DIR_REQUEST {
+			r.frames = append(r.frames, &replayFrame{cmd: msg.Cmd, ok: true,
+				data: msp.Encode(msg.Vers, msg.Dir, msg.Cmd, msg.Data)})
+		}
+	}
+	r.sync = true
+	return nil
+}
+
+// Timed returns true if the replay has timing (and so may be replayed in
+// real time)
+func (r *Replay) Timed() bool {
+	return r.timed
+}
+
+// SetSync selects replies on request (Reply) rather than in time (Due)
+func (r *Replay) SetSync(sync bool) {
+	r.sync = sync
+}
+
+// Due returns the frames due at or before secs from the start of replay
+func (r *Replay) Due(secs float64) [][]byte {
+	var fs [][]byte
+	t0 := r.frames[0].t
+	for ; r.next < len(r.frames) && r.frames[r.next].t-t0 <= secs; r.next++ {
+		r.frames[r.next].used = true
+		fs = append(fs, r.frames[r.next].data)
+	}
+	return fs
+}
+
+// Reply returns the next unused recorded reply to cmd
+func (r *Replay) Reply(cmd uint16) ([]byte, bool) {
+	for _, f := range r.frames {
+		if !f.used && f.ok && f.cmd == cmd {
+			f.used = true
+			return f.data, true
+		}
+	}
+	return nil, false
+}
+
+// Done returns true once all replies have been sent
+func (r *Replay) Done() bool {
+	for _, f := range r.frames[r.next:] {
+		if !f.used && (f.ok || !r.sync) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"msp"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/* A scenario is a timed list of events, read from a JSON file, e.g.
+ *
+ *  {"events": [
+ *    {"at": 2, "event": "arm"},
+ *    {"at": 5, "event": "poshold"},
+ *    {"at": 30, "event": "nak", "cmd": 209},
+ *    {"at": 60, "event": "end"}]}
+ *
+ * "at" is seconds from start up. The FC identity may also be set:
+ *
+ *  {"platform": "airplane", "variant": "INAV", "version": "7.1.0",
+ *   "name": "Wing", "events": [...]}
+ */
+
+type Event struct {
+	At    float64 `json:"at"`
+	Event string  `json:"event"`
+	Cmd   uint16  `json:"cmd,omitempty"`
+	Value int     `json:"value,omitempty"`
+}
+
+// Settings describe the emulated FC; empty values are unchanged
+type Settings struct {
+	Platform string `json:"platform,omitempty"`
+	Variant  string `json:"variant,omitempty"`
+	Version  string `json:"version,omitempty"`
+	Name     string `json:"name,omitempty"`
+}
+
+type Scenario struct {
+	Settings
+	Events []Event `json:"events"`
+}
+
+var platforms = map[string]uint8{
+	"multirotor": msp.PLATFORM_MULTIROTOR,
+	"airplane":   msp.PLATFORM_AIRPLANE,
+	"fixedwing":  msp.PLATFORM_AIRPLANE,
+	"helicopter": msp.PLATFORM_HELICOPTER,
+	"tricopter":  msp.PLATFORM_TRICOPTER,
+	"rover":      msp.PLATFORM_ROVER,
+	"boat":       msp.PLATFORM_BOAT,
+}
+
+// ParsePlatform accepts a platform name or number
+func ParsePlatform(s string) (uint8, error) {
+	if p, ok := platforms[strings.ToLower(s)]; ok {
+		return p, nil
+	}
+	n, err := strconv.ParseUint(s, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("unknown platform \"%s\"", s)
+	}
+	return uint8(n), nil
+}
+
+// ParseVersion parses a "major.minor.patch" version
+func ParseVersion(s string) (msp.FCVersion, error) {
+	var v [3]uint8
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return msp.FCVersion{}, fmt.Errorf("invalid version \"%s\"", s)
+	}
+	for j, p := range parts {
+		n, err := strconv.ParseUint(p, 10, 8)
+		if err != nil {
+			return msp.FCVersion{}, fmt.Errorf("invalid version \"%s\"", s)
+		}
+		v[j] = uint8(n)
+	}
+	return msp.FCVersion{Major: v[0], Minor: v[1], Patch: v[2]}, nil
+}
+
+// Event names, and what they do
+var eventHelp = map[string]string{
+	"arm":      "arm the vehicle",
+	"disarm":   "disarm the vehicle (also sets the navigation mode to idle)",
+	"idle":     "no navigation mode",
+	"poshold":  "POSHOLD, the vehicle flies to WP 255",
+	"rth":      "RTH, the vehicle flies to its start position",
+	"wp":       "WP mission mode",
+	"nofix":    "GPS reports no fix",
+	"fix":      "GPS fix restored",
+	"silent":   "stop answering MSP",
+	"resume":   "answer MSP again",
+	"nak":      "reply to MSP command \"cmd\" with an error",
+	"ack":      "reply normally to MSP command \"cmd\"",
+	"platform": "set the platform type (mixer) to \"value\"",
+	"end":      "exit the simulator",
+}
+
+func LoadScenario(name string) (*Scenario, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	s := &Scenario{}
+	if err = json.Unmarshal(b, s); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	for j := range s.Events {
+		s.Events[j].Event = strings.ToLower(s.Events[j].Event)
+		if _, ok := eventHelp[s.Events[j].Event]; !ok {
+			return nil, fmt.Errorf("%s: unknown event \"%s\" at %.1fs", name, s.Events[j].Event, s.Events[j].At)
+		}
+	}
+	sort.SliceStable(s.Events, func(i, j int) bool { return s.Events[i].At < s.Events[j].At })
+	return s, nil
+}
+
+// Due removes and returns the events due at or before secs
+func (s *Scenario) Due(secs float64) []Event {
+	n := 0
+	for n < len(s.Events) && s.Events[n].At <= secs {
+		n++
+	}
+	evs := s.Events[:n]
+	s.Events = s.Events[n:]
+	return evs
+}
+
+// SimState is the FC state, changed by key presses or scenario events
+type SimState struct {
+	Armed    bool
+	NavMode  uint8
+	NoFix    bool
+	Silent   bool
+	Platform uint8
+	Variant  string
+	Version  msp.FCVersion
+	Name     string
+	Nak      map[uint16]bool
+}
+
+func NewSimState() *SimState {
+	return &SimState{Platform: msp.PLATFORM_TRICOPTER, Variant: "INAV",
+		Version: msp.FCVersion{Major: 6, Minor: 6, Patch: 6}, Name: "Follower",
+		Nak: make(map[uint16]bool)}
+}
+
+// Configure applies the non-empty settings
+func (s *SimState) Configure(st Settings) error {
+	if st.Platform != "" {
+		p, err := ParsePlatform(st.Platform)
+		if err != nil {
+			return err
+		}
+		s.Platform = p
+	}
+	if st.Variant != "" {
+		// MSP_FC_VARIANT is four characters
+		if len(st.Variant) != 4 {
+			return fmt.Errorf("variant \"%s\" is not four characters", st.Variant)
+		}
+		s.Variant = st.Variant
+	}
+	if st.Version != "" {
+		v, err := ParseVersion(st.Version)
+		if err != nil {
+			return err
+		}
+		s.Version = v
+	}
+	if st.Name != "" {
+		s.Name = st.Name
+	}
+	return nil
+}
+
+// IsINAV returns false for other firmware, which does not implement the
+// INAV specific (MSPv2 0x2000 range) commands
+func (s *SimState) IsINAV() bool {
+	return s.Variant == "INAV"
+}
+
+// Apply applies an event, returning false for "end"
+func (s *SimState) Apply(ev Event) bool {
+	switch ev.Event {
+	case "arm":
+		s.Armed = true
+	case "disarm":
+		s.Armed = false
+		s.NavMode = msp.NAV_MODE_NONE
+	case "idle":
+		s.NavMode = msp.NAV_MODE_NONE
+	case "poshold":
+		s.NavMode = msp.NAV_MODE_HOLD
+	case "rth":
+		s.NavMode = msp.NAV_MODE_RTH
+	case "wp":
+		s.NavMode = msp.NAV_MODE_NAV
+	case "nofix":
+		s.NoFix = true
+	case "fix":
+		s.NoFix = false
+	case "silent":
+		s.Silent = true
+	case "resume":
+		s.Silent = false
+	case "nak":
+		s.Nak[ev.Cmd] = true
+	case "ack":
+		delete(s.Nak, ev.Cmd)
+	case "platform":
+		s.Platform = uint8(ev.Value)
+	case "end":
+		return false
+	}
+	return true
+}
+
+// Mode returns the navigation mode reported by MSP_NAV_STATUS
+func (s *SimState) Mode() uint8 {
+	if !s.Armed {
+		return msp.NAV_MODE_NONE
+	}
+	return s.NavMode
+}
+
+// HasFix returns true if the FC GPS has a fix (as the FC will not arm
+// without one, an unarmed vehicle reports no fix)
+func (s *SimState) HasFix() bool {
+	return s.Armed && !s.NoFix
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"math"
+)
+
+const (
+	ARRIVED   = 0.5 // m
+	M_PER_DEG = 111195.0
+)
+
+// Vehicle is a simple kinematic model; the vehicle turns towards its target
+// at no more than TurnRate, accelerating (at Accel) to no more than MaxSpeed
+// and slowing so as to stop at the target
+type Vehicle struct {
+	Lat      float64
+	Lon      float64
+	Spd      float64 // m/s
+	Cog      float64 // deg
+	Alt      float64 // m, relative to home
+	MaxSpeed float64 // m/s
+	Accel    float64 // m/s/s
+	TurnRate float64 // deg/s
+	tlat     float64
+	tlon     float64
+	target   bool
+}
+
+func NewVehicle(lat, lon, maxspeed, accel, turnrate float64) *Vehicle {
+	return &Vehicle{Lat: lat, Lon: lon, MaxSpeed: maxspeed, Accel: accel, TurnRate: turnrate}
+}
+
+func (v *Vehicle) SetTarget(lat, lon float64) {
+	v.tlat = lat
+	v.tlon = lon
+	v.target = true
+}
+
+func (v *Vehicle) ClearTarget() {
+	v.target = false
+}
+
+// offset returns the north and east distances (m) to a position, adequate
+// for the short distances involved
+func (v *Vehicle) offset(lat, lon float64) (float64, float64) {
+	dn := (lat - v.Lat) * M_PER_DEG
+	de := (lon - v.Lon) * M_PER_DEG * math.Cos(v.Lat*math.Pi/180)
+	return dn, de
+}
+
+func (v *Vehicle) move(dn, de float64) {
+	v.Lat += dn / M_PER_DEG
+	v.Lon += de / (M_PER_DEG * math.Cos(v.Lat*math.Pi/180))
+}
+
+// Update advances the model by dt seconds
+func (v *Vehicle) Update(dt float64) {
+	if dt <= 0 {
+		return
+	}
+	want := 0.0
+	if v.target {
+		dn, de := v.offset(v.tlat, v.tlon)
+		d := math.Hypot(dn, de)
+		if d < ARRIVED && v.Spd < v.Accel*dt {
+			v.Spd = 0
+			return
+		}
+		// turn towards the target
+		brg := math.Atan2(de, dn) * 180 / math.Pi
+		diff := math.Mod(brg-v.Cog+540, 360) - 180
+		turn := v.TurnRate * dt
+		if math.Abs(diff) <= turn {
+			v.Cog = brg
+			diff = 0
+		} else {
+			v.Cog += math.Copysign(turn, diff)
+			diff -= math.Copysign(turn, diff)
+		}
+		v.Cog = math.Mod(v.Cog+360, 360)
+		// the speed that allows stopping at the target, reduced when not
+		// heading towards it
+		want = math.Min(v.MaxSpeed, math.Sqrt(2*v.Accel*d))
+		want *= math.Max(0, math.Cos(diff*math.Pi/180))
+	}
+	dv := v.Accel * dt
+	if v.Spd < want {
+		v.Spd = math.Min(v.Spd+dv, want)
+	} else {
+		v.Spd = math.Max(v.Spd-dv, want)
+	}
+	if v.Spd > 0 {
+		s := v.Spd * dt
+		c := v.Cog * math.Pi / 180
+		v.move(s*math.Cos(c), s*math.Sin(c))
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// window is a period of seconds from the start, as "start:duration"
+type window struct {
+	start float64
+	dur   float64
+}
+
+func (w *window) String() string {
+	if w.dur == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%g:%g", w.start, w.dur)
+}
+
+func (w *window) Set(s string) error {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return fmt.Errorf("expected start:duration")
+	}
+	var err error
+	if w.start, err = strconv.ParseFloat(parts[0], 64); err != nil {
+		return err
+	}
+	w.dur, err = strconv.ParseFloat(parts[1], 64)
+	return err
+}
+
+func (w *window) in(t float64) bool {
+	return w.dur > 0 && t >= w.start && t < w.start+w.dur
+}
+
+// Generator produces NMEA sentences for a vehicle moving along a route
+type Generator struct {
+	route   Route
+	lat0    float64
+	lon0    float64
+	speed   float64 // m/s
+	rate    float64 // Hz
+	noise   float64 // m
+	sats    int
+	alt     float64 // m
+	badsum  float64 // probability of a bad checksum
+	talker  string
+	nofix   window
+	lowsats window
+	stamp   time.Time
+	epoch   int
+	en      float64 // noise state, m
+	ee      float64
+}
+
+// next returns the sentences for the next epoch; ok is false at the end
+// of the route
+func (g *Generator) next() ([]string, bool) {
+	secs := float64(g.epoch) / g.rate
+	stamp := g.stamp.Add(time.Duration(secs * float64(time.Second)))
+	g.epoch++
+
+	n, e, cog, ok := g.route.At(secs * g.speed)
+	spd := g.speed
+	if !ok {
+		spd = 0
+	}
+	cog = math.Mod(cog+360, 360)
+
+	// correlated (first order Gauss-Markov) position error, ~10s time
+	// constant
+	if g.noise > 0 {
+		a := math.Exp(-1 / (10 * g.rate))
+		s := g.noise * math.Sqrt(1-a*a)
+		g.en = a*g.en + s*rand.NormFloat64()
+		g.ee = a*g.ee + s*rand.NormFloat64()
+	}
+	lat := g.lat0 + (n+g.en)/M_PER_DEG
+	lon := g.lon0 + (e+g.ee)/(M_PER_DEG*math.Cos(g.lat0*math.Pi/180))
+
+	nsat := g.sats
+	if g.lowsats.in(secs) {
+		nsat = 4
+	}
+	fix := !g.nofix.in(secs)
+	if !fix {
+		nsat = rand.Intn(4)
+	}
+	hdop := 0.6 + 6.0/float64(nsat+1)
+
+	tm := stamp.Format("150405.00")
+	var ss []string
+	if fix {
+		la, ns := nmeaLatLon(lat, 2, "N", "S")
+		lo, ew := nmeaLatLon(lon, 3, "E", "W")
+		sd := hdop * 1.5
+		ss = append(ss,
+			fmt.Sprintf("GGA,%s,%s,%s,%s,%s,1,%02d,%.2f,%.1f,M,47.0,M,,", tm, la, ns, lo, ew, nsat, hdop, g.alt),
+			fmt.Sprintf("GSA,A,3,%s,%.2f,%.2f,%.2f", prns(nsat), hdop*1.6, hdop, hdop*1.3),
+			fmt.Sprintf("RMC,%s,A,%s,%s,%s,%s,%.3f,%.2f,%s,,,A", tm, la, ns, lo, ew, spd/KNOTS_TO_MS, cog, stamp.Format("020106")),
+			fmt.Sprintf("VTG,%.2f,T,,M,%.3f,N,%.3f,K,A", cog, spd/KNOTS_TO_MS, spd*3.6),
+			fmt.Sprintf("GST,%s,%.1f,%.1f,%.1f,0.0,%.1f,%.1f,%.1f", tm, sd, sd, sd, sd, sd, sd*1.5))
+	} else {
+		ss = append(ss,
+			fmt.Sprintf("GGA,%s,,,,,0,%02d,99.99,,,,,,", tm, nsat),
+			fmt.Sprintf("GSA,A,1,%s,99.99,99.99,99.99", prns(0)),
+			fmt.Sprintf("RMC,%s,V,,,,,,,%s,,,N", tm, stamp.Format("020106")),
+			"VTG,,,,,,,,,N")
+	}
+	// satellites in view and date, once a second
+	if epr := int(math.Max(1, math.Round(g.rate))); (g.epoch-1)%epr == 0 {
+		ss = append(ss, gsv(nsat)...)
+		ss = append(ss, fmt.Sprintf("ZDA,%s,%s,,", tm, stamp.Format("02,01,2006")))
+	}
+	for j, s := range ss {
+		talker := g.talker
+		if strings.HasPrefix(s, "GSV") {
+			// GSV is per constellation, never GN
+			talker = "GP"
+		}
+		ss[j] = g.sentence(talker + s)
+	}
+	return ss, ok
+}
+
+const KNOTS_TO_MS = 1852.0 / 3600.0
+
+// sentence adds the delimiter and checksum (occasionally corrupted)
+func (g *Generator) sentence(s string) string {
+	cs := byte(0)
+	for j := 0; j < len(s); j++ {
+		cs ^= s[j]
+	}
+	if g.badsum > 0 && rand.Float64() < g.badsum {
+		cs ^= 0x55
+	}
+	return fmt.Sprintf("$%s*%02X", s, cs)
+}
+
+// nmeaLatLon formats degrees as [d]ddmm.mmmmm and hemisphere
+func nmeaLatLon(v float64, width int, pos, neg string) (string, string) {
+	h := pos
+	if v < 0 {
+		h = neg
+		v = -v
+	}
+	d := math.Floor(v)
+	m := (v - d) * 60
+	if m >= 59.999995 {
+		d++
+		m = 0
+	}
+	return fmt.Sprintf("%0*d%08.5f", width, int(d), m), h
+}
+
+// gsv returns the GSV sentences for the satellites of prns(n), tracked,
+// and three more not tracked
+func gsv(n int) []string {
+	if n > 12 {
+		n = 12
+	}
+	view := n + 3
+	nmsg := (view + 3) / 4
+	var ss []string
+	for m := 0; m < nmsg; m++ {
+		s := fmt.Sprintf("GSV,%d,%d,%02d", nmsg, m+1, view)
+		for j := 4 * m; j < 4*m+4 && j < view; j++ {
+			snr := ""
+			if j < n {
+				snr = fmt.Sprintf("%02d", 30+(j*7)%20)
+			}
+			s += fmt.Sprintf(",%02d,%02d,%03d,%s", 2*j+1, 10+(j*13)%80, (j*47)%360, snr)
+		}
+		ss = append(ss, s)
+	}
+	return ss
+}
+
+// prns returns the twelve GSA satellite fields
+func prns(n int) string {
+	f := make([]string, 12)
+	for j := 0; j < n && j < 12; j++ {
+		f[j] = fmt.Sprintf("%02d", 2*j+1)
+	}
+	return strings.Join(f, ",")
+}
@@ -2,9 +2,15 @@ module gpsrd
 
 go 1.19
 
-require go.bug.st/serial v1.4.0
+require transport v1.0.0
 
 require (
 	github.com/creack/goselect v0.1.2 // indirect
+	go.bug.st/serial v1.4.0 // indirect
 	golang.org/x/sys v0.0.0-20210823070655-63515b42dcdf // indirect
+	msp v1.0.0 // indirect
 )
+
+replace msp v1.0.0 => ../../pkg/msp
+
+replace transport v1.0.0 => ../../pkg/transport
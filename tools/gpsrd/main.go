@@ -7,81 +7,159 @@ import (
 	"bufio"
 	"flag"
 	"fmt"
-	"go.bug.st/serial"
+	"io"
 	"log"
 	"os"
 	"strconv"
 	"strings"
 	"time"
+	"transport"
 )
 
+type output struct {
+	port io.Writer
+}
+
+func (o *output) write(l string) {
+	fmt.Println(l)
+	if o.port != nil {
+		_, err := o.port.Write([]byte(l + "\r\n"))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func replay(name string, out *output) {
+	file, err := os.Open(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+
+	last := 0.0
+	scanner := bufio.NewScanner(bufio.NewReader(file))
+	for scanner.Scan() {
+		l := scanner.Text()
+		parts := strings.Split(l, ",")
+		if len(parts) > 2 {
+			if len(parts[0]) == 6 && strings.HasSuffix(parts[0], "GGA") {
+				now, _ := strconv.ParseFloat(parts[1], 32)
+				if last != 0 {
+					diff := (now - last) * 1000
+					if diff > 0 {
+						time.Sleep(time.Duration(diff) * time.Millisecond)
+					}
+				}
+				last = now
+			}
+			out.write(l)
+		}
+	}
+}
+
+func generate(g *Generator, duration time.Duration, out *output) {
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / g.rate))
+	defer ticker.Stop()
+	for start := time.Now(); duration == 0 || time.Since(start) < duration; <-ticker.C {
+		ss, ok := g.next()
+		for _, s := range ss {
+			out.write(s)
+		}
+		if !ok {
+			break
+		}
+	}
+}
+
 func main() {
 
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage of gpsrd [options] file\n")
-		fmt.Fprintf(os.Stderr, " where \"file\" is a file containing NMEA sentences\n")
+		fmt.Fprintf(os.Stderr, "Usage of gpsrd [options] [file]\n")
+		fmt.Fprintf(os.Stderr, " where \"file\" is a file containing NMEA sentences (not required with -route)\n")
 		flag.PrintDefaults()
 	}
 
 	device := ""
 	baud := 9600
+	g := &Generator{}
 
-	flag.StringVar(&device, "device", "", "Serial device")
+	flag.StringVar(&device, "device", "", "Serial device (or pty, tcp://:port)")
 	flag.IntVar(&baud, "baud", 9600, "Baud rate")
+	route := flag.String("route", "", "Generate a track: line, circle, eight or a GPX file")
+	mode := flag.String("mode", "walk", "Speed for generated tracks: walk, cycle or drive")
+	speed := flag.Float64("speed", 0, "Speed (m/s), overrides -mode")
+	flag.Float64Var(&g.rate, "rate", 1, "Generated fix rate (Hz)")
+	flag.Float64Var(&g.lat0, "lat", 50.1, "Start latitude (not GPX)")
+	flag.Float64Var(&g.lon0, "lon", -1.0, "Start longitude (not GPX)")
+	hdg := flag.Float64("heading", 0, "Initial heading (deg, not GPX)")
+	radius := flag.Float64("radius", 50, "Circle / figure of eight radius (m)")
+	flag.Float64Var(&g.noise, "noise", 0, "Position noise (m)")
+	flag.IntVar(&g.sats, "sats", 12, "Satellites")
+	flag.Float64Var(&g.alt, "alt", 45, "Altitude (m)")
+	duration := flag.Duration("duration", 0, "Duration of generated tracks (0 = forever, or end of GPX)")
+	flag.Var(&g.nofix, "nofix", "No fix for period (start:duration seconds)")
+	flag.Var(&g.lowsats, "lowsats", "Low satellite count for period (start:duration seconds)")
+	badsum := flag.Float64("badsum", 0, "Sentences with bad checksums (%)")
+	flag.StringVar(&g.talker, "talker", "GP", "Talker for generated sentences (e.g. GP, GN)")
 	flag.Parse()
 
-	last := 0.0
-	var port serial.Port
 	files := flag.Args()
-	if len(files) > 0 {
-		file, err := os.Open(files[0])
+	if *route == "" && len(files) == 0 {
+		flag.Usage()
+		return
+	}
+
+	out := &output{}
+	if device != "" {
+		port, err := transport.Open(device, baud)
 		if err != nil {
 			log.Fatal(err)
 		}
-		defer file.Close()
-		if device != "" {
-			mode := &serial.Mode{
-				BaudRate: baud,
-			}
-			port, err = serial.Open(device, mode)
-			if err != nil {
-				panic(err)
-			}
+		defer port.Close()
+		if n, ok := port.(transport.Named); ok {
+			fmt.Fprintf(os.Stderr, "Writing to %s\n", n.Name())
 		}
+		// input is discarded (reading also accepts network clients)
+		go io.Copy(io.Discard, port)
+		out.port = port
+	}
 
-		fh := bufio.NewReader(file)
-		scanner := bufio.NewScanner(fh)
-		if scanner != nil {
-			for scanner.Scan() {
-				l := scanner.Text()
-				parts := strings.Split(l, ",")
-				if len(parts) > 2 {
-					if parts[0] == "$GPGGA" {
-						now, _ := strconv.ParseFloat(parts[1], 32)
-						if last != 0 {
-							diff := (now - last) * 1000
-							if diff > 0 {
-								time.Sleep(time.Duration(diff) * time.Millisecond)
-							}
-						}
-						last = now
-					}
-					fmt.Println(l)
-					if device != "" {
-						_, err := port.Write([]byte(l))
-						if err == nil {
-							_, err = port.Write([]byte("\r\n"))
-						}
-						if err != nil {
-							log.Fatal(err)
-						}
-					}
-				}
-			}
-		} else {
+	if *route == "" {
+		replay(files[0], out)
+		return
+	}
+
+	speeds := map[string]float64{"walk": 1.4, "cycle": 5.0, "drive": 15.0}
+	g.speed = *speed
+	if g.speed == 0 {
+		var ok bool
+		if g.speed, ok = speeds[*mode]; !ok {
+			log.Fatalf("Unknown mode %s\n", *mode)
+		}
+	}
+	if len(g.talker) != 2 {
+		log.Fatalf("Invalid talker %s\n", g.talker)
+	}
+	if g.rate <= 0 {
+		log.Fatalf("Invalid rate %g\n", g.rate)
+	}
+	g.badsum = *badsum / 100
+	g.stamp = time.Now().UTC().Truncate(time.Second)
+
+	switch *route {
+	case "line":
+		g.route = rotated{lineRoute{}, *hdg}
+	case "circle":
+		g.route = rotated{circleRoute{*radius}, *hdg}
+	case "eight":
+		g.route = rotated{eightRoute{*radius}, *hdg}
+	default:
+		var err error
+		g.route, g.lat0, g.lon0, err = NewGPXRoute(*route)
+		if err != nil {
 			log.Fatal(err)
 		}
-	} else {
-		flag.Usage()
 	}
+	generate(g, *duration, out)
 }
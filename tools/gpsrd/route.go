@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"os"
+)
+
+const M_PER_DEG = 111195.0
+
+// Route gives the position (north and east metres from the start) and
+// course at distance d along a route; ok is false past the end
+type Route interface {
+	At(d float64) (n, e, cog float64, ok bool)
+}
+
+// straight line, north
+type lineRoute struct{}
+
+func (lineRoute) At(d float64) (float64, float64, float64, bool) {
+	return d, 0, 0, true
+}
+
+// clockwise circle of radius r, starting northbound
+type circleRoute struct {
+	r float64
+}
+
+func (c circleRoute) At(d float64) (float64, float64, float64, bool) {
+	th := d / c.r
+	return c.r * math.Sin(th), c.r * (1 - math.Cos(th)), th * 180 / math.Pi, true
+}
+
+// figure of eight; a clockwise circle then an anti-clockwise circle of
+// radius r, crossing at the start
+type eightRoute struct {
+	r float64
+}
+
+func (c eightRoute) At(d float64) (float64, float64, float64, bool) {
+	th := math.Mod(d/c.r, 4*math.Pi)
+	if th < 2*math.Pi {
+		return c.r * math.Sin(th), c.r * (1 - math.Cos(th)), th * 180 / math.Pi, true
+	}
+	th -= 2 * math.Pi
+	return c.r * math.Sin(th), -c.r * (1 - math.Cos(th)), -th * 180 / math.Pi, true
+}
+
+// rotated turns a route to start on heading hdg
+type rotated struct {
+	Route
+	hdg float64
+}
+
+func (r rotated) At(d float64) (float64, float64, float64, bool) {
+	n, e, cog, ok := r.Route.At(d)
+	h := r.hdg * math.Pi / 180
+	return n*math.Cos(h) - e*math.Sin(h), n*math.Sin(h) + e*math.Cos(h), cog + r.hdg, ok
+}
+
+// gpxRoute follows the points of a GPX track or route
+type gpxRoute struct {
+	n, e, d []float64
+}
+
+type gpxPoint struct {
+	Lat float64 `xml:"lat,attr"`
+	Lon float64 `xml:"lon,attr"`
+}
+
+type gpxFile struct {
+	Trk []struct {
+		Seg []struct {
+			Pts []gpxPoint `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+	Rte []struct {
+		Pts []gpxPoint `xml:"rtept"`
+	} `xml:"rte"`
+}
+
+// NewGPXRoute reads the first track (or route) of a GPX file, returning
+// the route and its start position
+func NewGPXRoute(name string) (Route, float64, float64, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	var g gpxFile
+	if err = xml.Unmarshal(b, &g); err != nil {
+		return nil, 0, 0, fmt.Errorf("%s: %w", name, err)
+	}
+	var pts []gpxPoint
+	for _, t := range g.Trk {
+		for _, s := range t.Seg {
+			pts = append(pts, s.Pts...)
+		}
+		if len(pts) > 0 {
+			break
+		}
+	}
+	if len(pts) == 0 && len(g.Rte) > 0 {
+		pts = g.Rte[0].Pts
+	}
+	if len(pts) < 2 {
+		return nil, 0, 0, fmt.Errorf("%s: no track", name)
+	}
+	lat0, lon0 := pts[0].Lat, pts[0].Lon
+	r := &gpxRoute{}
+	for j, p := range pts {
+		n := (p.Lat - lat0) * M_PER_DEG
+		e := (p.Lon - lon0) * M_PER_DEG * math.Cos(lat0*math.Pi/180)
+		d := 0.0
+		if j > 0 {
+			d = r.d[j-1] + math.Hypot(n-r.n[j-1], e-r.e[j-1])
+		}
+		r.n = append(r.n, n)
+		r.e = append(r.e, e)
+		r.d = append(r.d, d)
+	}
+	return r, lat0, lon0, nil
+}
+
+func (r *gpxRoute) At(d float64) (float64, float64, float64, bool) {
+	last := len(r.d) - 1
+	j := 1
+	for j < last && r.d[j] < d {
+		j++
+	}
+	dn := r.n[j] - r.n[j-1]
+	de := r.e[j] - r.e[j-1]
+	cog := math.Atan2(de, dn) * 180 / math.Pi
+	if d >= r.d[last] {
+		return r.n[last], r.e[last], cog, false
+	}
+	f := 0.0
+	if seg := r.d[j] - r.d[j-1]; seg > 0 {
+		f = (d - r.d[j-1]) / seg
+	}
+	return r.n[j-1] + f*dn, r.e[j-1] + f*de, cog, true
+}
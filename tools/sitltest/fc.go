@@ -0,0 +1,109 @@
+package sitltest
+
+import (
+	"msp"
+	"sync"
+)
+
+// StandIn is a scripted FC, answering the follower over a MSP transport as
+// INAV (or SITL) would. Counters allow the harness to wait until every
+// request has been answered.
+type StandIn struct {
+	t        msp.Transport
+	accept   byte
+	mu       sync.Mutex
+	hold     bool
+	silent   bool
+	lat      int32
+	lon      int32
+	wps      map[uint8]msp.Waypoint
+	wpchan   chan msp.Waypoint
+	received int
+	replied  int
+}
+
+func NewStandIn(t msp.Transport, accept byte, lat, lon float64) *StandIn {
+	return &StandIn{t: t, accept: accept, lat: int32(lat * 1e7), lon: int32(lon * 1e7),
+		wps: make(map[uint8]msp.Waypoint), wpchan: make(chan msp.Waypoint, 256)}
+}
+
+// SetHold sets the INAV navigation mode to POSHOLD (or idle)
+func (s *StandIn) SetHold(hold bool) {
+	s.mu.Lock()
+	s.hold = hold
+	s.mu.Unlock()
+}
+
+// SetSilent simulates loss of the MSP link; requests are read but not
+// answered
+func (s *StandIn) SetSilent(silent bool) {
+	s.mu.Lock()
+	s.silent = silent
+	s.mu.Unlock()
+}
+
+// Counts returns the number of requests read and replies sent
+func (s *StandIn) Counts() (int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.received, s.replied
+}
+
+func (s *StandIn) Serve() error {
+	sd := msp.NewStreamDecoder(s.t)
+	for {
+		msg, err := sd.Next()
+		if err != nil {
+			return err
+		}
+		if msg.Dir != msp.DIR_REQUEST {
+			continue
+		}
+		s.mu.Lock()
+		s.received++
+		if msg.Ok && !s.silent && (s.accept == msp.MSP_AUTO || msg.Vers == s.accept) {
+			dir, payload := s.reply(msg)
+			s.t.Write(msp.Encode(msg.Vers, dir, msg.Cmd, payload))
+			s.replied++
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *StandIn) reply(msg msp.MSPMsg) (byte, []byte) {
+	switch msg.Cmd {
+	case msp.MSP_FC_VARIANT:
+		return msp.DIR_RESPONSE, []byte("INAV")
+	case msp.MSP_FC_VERSION:
+		return msp.DIR_RESPONSE, msp.FCVersion{Major: 7, Minor: 1, Patch: 0}.Encode()
+	case msp.MSP_NAME:
+		return msp.DIR_RESPONSE, []byte("sitltest")
+	case msp.MSP2_INAV_MIXER:
+		return msp.DIR_RESPONSE, msp.InavMixer{PlatformType: msp.PLATFORM_MULTIROTOR}.Encode()
+	case msp.MSP_NAV_STATUS:
+		ns := msp.NavStatus{}
+		if s.hold {
+			ns.Mode = msp.NAV_MODE_HOLD
+		}
+		return msp.DIR_RESPONSE, ns.Encode()
+	case msp.MSP_RAW_GPS:
+		g := msp.RawGPS{FixType: 2, NumSat: 12, Lat: s.lat, Lon: s.lon, Alt: 45, Hdop: 100}
+		return msp.DIR_RESPONSE, g.Encode()
+	case msp.MSP_SET_WP:
+		wp, err := msp.DecodeWaypoint(msg.Data)
+		if err != nil {
+			return msp.DIR_ERROR, nil
+		}
+		s.wps[wp.Number] = wp
+		s.wpchan <- wp
+		return msp.DIR_RESPONSE, nil
+	case msp.MSP_WP:
+		if len(msg.Data) > 0 {
+			if wp, ok := s.wps[msg.Data[0]]; ok {
+				return msp.DIR_RESPONSE, wp.Encode()
+			}
+			return msp.DIR_RESPONSE, msp.Waypoint{Number: msg.Data[0]}.Encode()
+		}
+	}
+	return msp.DIR_ERROR, nil
+}
@@ -0,0 +1,351 @@
+package sitltest
+
+import (
+	"bufio"
+	"follow"
+	"gps"
+	"math"
+	"msp"
+	"os"
+	"sync"
+	"testing"
+	"time"
+	"transport"
+)
+
+/* Integration test for the follower: a scripted FC on a local TCP port
+ * (as SITL), a recorded NMEA track through the GPS parser and a simulated
+ * clock. The follower is run in virtual time; after each event the harness
+ * waits until every MSP request has been answered, so runs are repeatable
+ * and take a few seconds. */
+
+// Scenario, in virtual time from start up
+const (
+	FIX_START  = 6 * time.Second
+	HOLD_AT    = 15 * time.Second
+	LOSS_AT    = 40 * time.Second
+	RESTORE_AT = 55 * time.Second
+	SETTLE     = 2 * time.Second // real time limit for MSP replies
+)
+
+type simClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *simClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *simClock) Set(t time.Time) {
+	c.mu.Lock()
+	c.t = t
+	c.mu.Unlock()
+}
+
+// counter counts the frames written by the follower
+type counter struct {
+	msp.Transport
+	mu sync.Mutex
+	n  int
+}
+
+func (c *counter) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	c.n++
+	c.mu.Unlock()
+	return c.Transport.Write(b)
+}
+
+func (c *counter) Count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.n
+}
+
+type nullDisplay struct{}
+
+func (nullDisplay) InitScreen(vb bool)                    {}
+func (nullDisplay) ShowMode(amode int16, imode int16)     {}
+func (nullDisplay) ClearTime(fail bool)                   {}
+func (nullDisplay) ShowTime(t string)                     {}
+func (nullDisplay) ShowGPS(nsat uint16, fix uint8)        {}
+func (nullDisplay) ShowINAVVers(t string)                 {}
+func (nullDisplay) ShowINAVSats(nsat uint16, hdop uint16) {}
+func (nullDisplay) ShowINAVPos(dist uint, brg uint16)     {}
+func (nullDisplay) ShowFenceHold(t string)                {}
+func (nullDisplay) ShowFenceFlag(c byte)                  {}
+func (nullDisplay) ClearINAVPos()                         {}
+func (nullDisplay) ClearINAVSats()                        {}
+func (nullDisplay) INAVReset()                            {}
+
+// logger shows the follower's log in virtual time (go test -v)
+type logger struct {
+	h *Harness
+}
+
+func (l logger) Log(s string) {
+	l.h.t.Logf("[%6.1f] %s", l.h.elapsed().Seconds(), s)
+}
+
+type stateChange struct {
+	at    time.Duration
+	state int
+}
+
+type wpEvent struct {
+	at  time.Duration
+	wp  msp.Waypoint
+	fix gps.Fix
+}
+
+type Harness struct {
+	t      *testing.T
+	clock  *simClock
+	start  time.Time
+	fc     *StandIn
+	w      *counter
+	mchan  chan msp.MSPMsg
+	fm     *follow.Follower
+	recv   int
+	last   gps.Fix
+	states []stateChange
+	wps    []wpEvent
+}
+
+func (h *Harness) elapsed() time.Duration {
+	return h.clock.Now().Sub(h.start)
+}
+
+// settle processes replies until the FC has read every request and the
+// follower has read every reply
+func (h *Harness) settle() {
+	deadline := time.Now().Add(SETTLE)
+	for {
+		rx, tx := h.fc.Counts()
+		if rx == h.w.Count() && h.recv == tx {
+			break
+		}
+		select {
+		case msg := <-h.mchan:
+			h.recv++
+			h.fm.Message(msg)
+		case <-time.After(time.Millisecond):
+			if time.Now().After(deadline) {
+				h.t.Fatalf("MSP stalled at %v (sent %d, FC read %d, replied %d, received %d)",
+					h.elapsed(), h.w.Count(), rx, tx, h.recv)
+			}
+		}
+	}
+	for {
+		select {
+		case wp := <-h.fc.wpchan:
+			h.wps = append(h.wps, wpEvent{at: h.elapsed(), wp: wp, fix: h.last})
+		default:
+			if n := len(h.states); n == 0 || h.states[n-1].state != h.fm.State() {
+				h.states = append(h.states, stateChange{h.elapsed(), h.fm.State()})
+			}
+			return
+		}
+	}
+}
+
+// firstState returns the first entry into state at or after t
+func (h *Harness) firstState(state int, t time.Duration) (time.Duration, bool) {
+	for _, s := range h.states {
+		if s.at >= t && s.state == state {
+			return s.at, true
+		}
+	}
+	return 0, false
+}
+
+// firstLeave returns the first change from state at or after t
+func (h *Harness) firstLeave(state int, t time.Duration) (time.Duration, bool) {
+	for i := 1; i < len(h.states); i++ {
+		s := h.states[i]
+		if s.at >= t && h.states[i-1].state == state && s.state != state {
+			return s.at, true
+		}
+	}
+	return 0, false
+}
+
+// followWPs returns the follow waypoints sent between t0 and t1
+func (h *Harness) followWPs(t0, t1 time.Duration) []wpEvent {
+	var ws []wpEvent
+	for _, w := range h.wps {
+		if w.wp.Number == follow.FOLLOW_WP && w.at >= t0 && w.at < t1 {
+			ws = append(ws, w)
+		}
+	}
+	return ws
+}
+
+func loadTrack(name string) ([]gps.Fix, error) {
+	fh, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	var fixes []gps.Fix
+	p := gps.NewNMEAParser()
+	rd := bufio.NewReader(fh)
+	for {
+		c, err := rd.ReadByte()
+		if err != nil {
+			break
+		}
+		if p.Parse(c) {
+			fixes = append(fixes, p.Fix)
+		}
+	}
+	return fixes, nil
+}
+
+// metres between two positions, adequate for small distances
+func metres(lat0, lon0, lat1, lon1 float64) float64 {
+	dy := (lat1 - lat0) * 111195.0
+	dx := (lon1 - lon0) * 111195.0 * math.Cos(lat0*math.Pi/180)
+	return math.Sqrt(dx*dx + dy*dy)
+}
+
+func TestFollowScenario(t *testing.T) {
+	fixes, err := loadTrack("testdata/track.nmea")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fixes) == 0 {
+		t.Fatal("no fixes in testdata/track.nmea")
+	}
+	// the FC accepts only one MSP version; the follower auto-detects it
+	for _, tc := range []struct {
+		name   string
+		accept byte
+	}{
+		{"MSPv1", msp.MSP_V1},
+		{"MSPv2", msp.MSP_V2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			runScenario(t, fixes, tc.accept)
+		})
+	}
+}
+
+func runScenario(t *testing.T, fixes []gps.Fix, accept byte) {
+	h := &Harness{t: t, clock: &simClock{}, mchan: make(chan msp.MSPMsg, 16)}
+	h.start = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	h.clock.Set(h.start)
+
+	srv, err := transport.NewTCPServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	// the vehicle holds 50m south of the start of the track
+	h.fc = NewStandIn(srv, accept, float64(fixes[0].Lat)-50.0/111195.0, float64(fixes[0].Lon))
+	go h.fc.Serve()
+
+	cl, err := transport.Open("tcp://"+srv.Addr().String(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	h.w = &counter{Transport: cl}
+	m := msp.NewMSPReader(h.w, h.mchan)
+	go m.Reader()
+
+	cfg := follow.Config{
+		MinSat:            6,
+		DontFollowType:    msp.PLATFORM_AIRPLANE,
+		MinFollowDist:     2.0,
+		TimeFormat:        "15:04:05",
+		MspRetries:        3,
+		MspTimeout:        500 * time.Millisecond,
+		WpVerifyRetries:   2,
+		WpVerifyTolerance: 1.0,
+		KfAccel:           1.0,
+	}
+	h.fm = follow.NewFollower(cfg, m, h.clock, nullDisplay{}, logger{h})
+
+	end := FIX_START + fixes[len(fixes)-1].Sub(fixes[0]) + 2*time.Second
+	nfix := 0
+	for tt := follow.TICK; tt <= end; tt += follow.TICK {
+		h.clock.Set(h.start.Add(tt))
+		switch tt {
+		case HOLD_AT:
+			h.fc.SetHold(true)
+		case LOSS_AT:
+			h.fc.SetSilent(true)
+		case RESTORE_AT:
+			h.fc.SetSilent(false)
+		}
+		for nfix < len(fixes) && FIX_START+fixes[nfix].Sub(fixes[0]) <= tt {
+			h.last = fixes[nfix]
+			h.fm.Fix(fixes[nfix])
+			nfix++
+			h.settle()
+		}
+		h.fm.Tick()
+		h.settle()
+	}
+	for _, s := range h.states {
+		t.Logf("state %d at %v", s.state, s.at)
+	}
+
+	navtimeout := time.Duration(follow.NAV_TIMEOUT) * follow.TICK
+
+	at, ok := h.firstState(follow.MSP_INIT_INIT, 0)
+	if !ok || at != time.Duration(follow.SPLASH_TIMEOUT)*follow.TICK {
+		t.Errorf("splash: initialised %v at %v", ok, at)
+	}
+
+	at, ok = h.firstState(follow.MSP_INIT_DONE, 0)
+	if !ok || at < FIX_START || at > FIX_START+time.Second {
+		t.Errorf("connect: connected %v at %v", ok, at)
+	}
+
+	if ws := h.followWPs(0, HOLD_AT); len(ws) != 0 {
+		t.Errorf("no follow before hold: %d waypoints", len(ws))
+	}
+
+	ws := h.followWPs(HOLD_AT, LOSS_AT)
+	if len(ws) == 0 || ws[0].at > HOLD_AT+1100*time.Millisecond {
+		t.Errorf("follow starts: %d waypoints", len(ws))
+	}
+
+	if expect := int((LOSS_AT - HOLD_AT) / time.Second); len(ws) < expect-1 {
+		t.Errorf("follow rate: %d waypoints, expected %d", len(ws), expect)
+	}
+	for i := 1; i < len(ws); i++ {
+		if dt := ws[i].at - ws[i-1].at; dt < 900*time.Millisecond || dt > 1100*time.Millisecond {
+			t.Errorf("follow rate: interval %v at %v", dt, ws[i].at)
+			break
+		}
+	}
+
+	for _, w := range h.followWPs(0, end+time.Second) {
+		d := metres(float64(w.fix.Lat), float64(w.fix.Lon), float64(w.wp.Lat)/1e7, float64(w.wp.Lon)/1e7)
+		if d > 1.0 {
+			t.Errorf("follow position: waypoint %.1fm from the user at %v", d, w.at)
+			break
+		}
+	}
+
+	at, ok = h.firstLeave(follow.MSP_INIT_DONE, LOSS_AT)
+	if !ok || at < LOSS_AT+navtimeout-200*time.Millisecond || at > LOSS_AT+navtimeout+300*time.Millisecond {
+		t.Errorf("link loss: disconnected %v at %v", ok, at)
+	}
+
+	at, ok = h.firstState(follow.MSP_INIT_DONE, RESTORE_AT)
+	if !ok || at > RESTORE_AT+3*time.Second {
+		t.Errorf("reconnect: reconnected %v at %v", ok, at)
+	}
+
+	ws = h.followWPs(RESTORE_AT, end+time.Second)
+	if len(ws) == 0 || ws[0].at > RESTORE_AT+4*time.Second {
+		t.Errorf("follow resumes: %d waypoints", len(ws))
+	}
+}